@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// commandList collects the -c flags in the order given
+type commandList []string
+
+func (c *commandList) String() string {
+	return strings.Join(*c, " | ")
+}
+
+func (c *commandList) Set(cmd string) error {
+	*c = append(*c, cmd)
+	return nil
+}
+
+// Batch runs commands against the buffer without a screen, stopping at the
+// first one that fails or quits. Lists meant for the pager are printed to
+// stdout, and a command that would wait for an answer fails since nobody
+// is there to give one.
+func Batch(s *State, commands []string) error {
+	for _, cmd := range commands {
+		if err := RunCommand(s, strings.TrimPrefix(cmd, ":")); err != nil {
+			return fmt.Errorf("%s: %w", cmd, err)
+		}
+		switch s.status {
+		case PAGER:
+			for _, m := range s.pager {
+				fmt.Println(m.text)
+			}
+			s.pager = nil
+		case CONFIRM:
+			return fmt.Errorf("%s: %s", cmd, s.question)
+		}
+		s.status = NORMAL
+		if s.quit {
+			return nil
+		}
+	}
+	return nil
+}
+
+// RunBatch is main for -batch: unless the file failed to open with openerr
+// it runs the -c commands, then prints the first error on stderr and exits
+// with status 1, or prints the --stdout text
+func RunBatch(s *State, commands []string, openerr error) {
+	err := openerr
+	if err == nil {
+		err = Batch(s, commands)
+	}
+	CloseDocuments(s)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gotext:", err)
+		os.Exit(1)
+	}
+	if s.stdout {
+		os.Stdout.WriteString(s.output)
+	}
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"unicode"
+	"unicode/utf8"
+)
+
+// gap buffer
+type TextBuffer interface {
+	ReadAll() string
+	Write(string) error
+	WriteChar(rune) error
+	Delete() error
+	DeleteForward() error
+	DeleteRange(int, int) error
+	ReplaceRange(int, int, string) error
+	ChangeCursorPosition(int, int) error
+	Offset(int, int) (int, error)
+	LineLength() int
+	LineCount() int
+	LineStart(int) int
+	Indent() int
+	CursorColumn(int) int
+	Select(int, int) error
+	Selection() (int, int)
+	SelectedText() string
+	Slice(int, int) string
+	RuneAt(int) rune
+	Find(*regexp.Regexp, int) (int, int)
+	FindBefore(*regexp.Regexp, int) (int, int)
+	Cursor() int
+	SetCursor(int) error
+	LineBounds(int) (int, int)
+	CursorYX() (int, int)
+	Len() int
+	Undo() bool
+	Redo() bool
+	Travel(int) bool
+	UndoTree() []UndoState
+	WriteHistory(io.Writer, string) error
+	ReadHistory(io.Reader) error
+	Checkpoint()
+	SetReadOnly(bool)
+	ReadOnly() bool
+	Modified() bool
+	Version() int
+	Changed() (int, bool)
+	MarkSaved()
+	SetMark(rune, int)
+	Mark(rune) (int, bool)
+	AddJump(int)
+	Jumps() []int
+	MarkLines([]int)
+	LineMark(int) (int, bool)
+}
+
+// minimum number of free slots allocated whenever the gap fills up
+const minGap = 64
+
+// runes in data[gapStart:gapEnd] are unused, everything before the gap is
+// left of the cursor and everything after it is right of the cursor
+type TextGapBuffer struct {
+	data     []rune
+	gapStart int
+	gapEnd   int
+	// selection as [selFrom, selTo) offsets, empty when equal
+	selFrom  int
+	selTo    int
+	history  editLog
+	marks    map[rune]int // offsets of marks, kept in place across edits
+	jumps    []int        // jump list offsets, oldest first
+	lines    []int        // line marks of a running :g, -1 once the line is gone
+	readonly bool         // edits fail with ErrReadOnly
+	changed  int          // 1 + the lowest offset edited since Changed, 0 for none
+	starts   []int        // offsets lines start at, cut back from the lowest edit
+	indexed  bool         // starts runs to the end of the buffer
+}
+
+// ErrReadOnly is returned by every edit to a read-only buffer
+var ErrReadOnly = errors.New("E45: 'readonly' option is set")
+
+func NewTextGapBuffer(text string) (*TextGapBuffer, error) {
+	tgb := &TextGapBuffer{}
+	tgb.insert([]rune(text))
+	return tgb, nil
+}
+
+// Len is the number of runes stored, not counting the gap
+func (tgb *TextGapBuffer) Len() int {
+	return len(tgb.data) - (tgb.gapEnd - tgb.gapStart)
+}
+
+func (tgb *TextGapBuffer) ReadAll() string {
+	out := make([]rune, 0, tgb.Len())
+	out = append(out, tgb.data[:tgb.gapStart]...)
+	out = append(out, tgb.data[tgb.gapEnd:]...)
+	return string(out)
+}
+
+func (tgb *TextGapBuffer) Write(text string) error {
+	if tgb.readonly {
+		return ErrReadOnly
+	}
+	runes := []rune(text)
+	tgb.record(edit{offset: tgb.gapStart, text: runes, insert: true})
+	tgb.insert(runes)
+	return nil
+}
+
+func (tgb *TextGapBuffer) WriteChar(char rune) error {
+	return tgb.Write(string(char))
+}
+
+// Delete removes the rune before the cursor. Since the buffer stores runes
+// a multi-byte character is always removed whole, and deleting at the start
+// of the buffer does nothing.
+func (tgb *TextGapBuffer) Delete() error {
+	if tgb.readonly {
+		return ErrReadOnly
+	}
+	if tgb.gapStart > 0 {
+		tgb.record(edit{offset: tgb.gapStart - 1, text: []rune{tgb.at(tgb.gapStart - 1)}})
+		tgb.MoveGapTo(tgb.gapStart - 1)
+		tgb.remove(1)
+	}
+	return nil
+}
+
+// DeleteForward removes the rune after the cursor, joining the next line on
+// when it is a newline. At the end of the buffer it does nothing.
+func (tgb *TextGapBuffer) DeleteForward() error {
+	if tgb.readonly {
+		return ErrReadOnly
+	}
+	if tgb.gapEnd < len(tgb.data) {
+		tgb.record(edit{offset: tgb.gapStart, text: []rune{tgb.data[tgb.gapEnd]}})
+		tgb.remove(1)
+	}
+	return nil
+}
+
+// DeleteRange removes the runes in [from, to) and leaves the cursor at from
+func (tgb *TextGapBuffer) DeleteRange(from int, to int) error {
+	if from < 0 || to > tgb.Len() || from > to {
+		return errors.New("range out of bounds")
+	}
+	if tgb.readonly {
+		return ErrReadOnly
+	}
+	if from == to {
+		return tgb.MoveGapTo(from)
+	}
+	tgb.record(edit{offset: from, text: []rune(tgb.Slice(from, to))})
+	tgb.MoveGapTo(from)
+	tgb.remove(to - from)
+	return nil
+}
+
+// ReplaceRange swaps the runes in [from, to) for text and leaves the cursor
+// after the new text
+func (tgb *TextGapBuffer) ReplaceRange(from int, to int, text string) error {
+	if err := tgb.DeleteRange(from, to); err != nil {
+		return err
+	}
+	return tgb.Write(text)
+}
+
+// SetReadOnly makes every edit to the buffer fail, or allows them again
+func (tgb *TextGapBuffer) SetReadOnly(readonly bool) {
+	tgb.readonly = readonly
+}
+
+func (tgb *TextGapBuffer) ReadOnly() bool {
+	return tgb.readonly
+}
+
+// Changed is the lowest offset edited since it was last called, if any
+func (tgb *TextGapBuffer) Changed() (int, bool) {
+	offset := tgb.changed - 1
+	tgb.changed = 0
+	return offset, offset >= 0
+}
+
+// touch records an edit at offset for Changed and drops the line starts
+// from there on. A line starting at offset itself goes too, as its newline
+// may have just become the last rune.
+func (tgb *TextGapBuffer) touch(offset int) {
+	if tgb.changed == 0 || offset < tgb.changed-1 {
+		tgb.changed = offset + 1
+	}
+	n, _ := slices.BinarySearch(tgb.starts, offset)
+	tgb.starts, tgb.indexed = tgb.starts[:n], false
+}
+
+// lineStarts is the offset every line starts at, scanning on from the last
+// one still known after an edit
+func (tgb *TextGapBuffer) lineStarts() []int {
+	if tgb.indexed {
+		return tgb.starts
+	}
+	if len(tgb.starts) == 0 {
+		tgb.starts = append(tgb.starts, 0)
+	}
+	for i := tgb.starts[len(tgb.starts)-1]; i < tgb.Len()-1; i++ {
+		if tgb.at(i) == '\n' {
+			tgb.starts = append(tgb.starts, i+1)
+		}
+	}
+	tgb.indexed = true
+	return tgb.starts
+}
+
+// insert puts runes before the gap without recording history
+func (tgb *TextGapBuffer) insert(runes []rune) {
+	tgb.shiftMarks(tgb.gapStart, len(runes))
+	tgb.touch(tgb.gapStart)
+	tgb.grow(len(runes))
+	copy(tgb.data[tgb.gapStart:], runes)
+	tgb.gapStart += len(runes)
+}
+
+// remove drops n runes after the gap without recording history
+func (tgb *TextGapBuffer) remove(n int) {
+	n = min(n, len(tgb.data)-tgb.gapEnd)
+	tgb.dropMarks(tgb.gapStart, tgb.gapStart+n)
+	tgb.touch(tgb.gapStart)
+	tgb.gapEnd += n
+}
+
+// MoveGapTo places the gap (and therefore the insertion point) before the
+// rune at offset
+func (tgb *TextGapBuffer) MoveGapTo(offset int) error {
+	if offset < 0 || offset > tgb.Len() {
+		return errors.New("offset out of range")
+	}
+	if offset < tgb.gapStart {
+		n := tgb.gapStart - offset
+		copy(tgb.data[tgb.gapEnd-n:tgb.gapEnd], tgb.data[offset:tgb.gapStart])
+		tgb.gapStart -= n
+		tgb.gapEnd -= n
+	} else if offset > tgb.gapStart {
+		n := offset - tgb.gapStart
+		copy(tgb.data[tgb.gapStart:], tgb.data[tgb.gapEnd:tgb.gapEnd+n])
+		tgb.gapStart += n
+		tgb.gapEnd += n
+	}
+	return nil
+}
+
+// grow makes sure the gap can hold at least n more runes
+func (tgb *TextGapBuffer) grow(n int) {
+	if tgb.gapEnd-tgb.gapStart >= n {
+		return
+	}
+	size := 2*len(tgb.data) + n + minGap
+	data := make([]rune, size)
+	copy(data, tgb.data[:tgb.gapStart])
+	right := len(tgb.data) - tgb.gapEnd
+	copy(data[size-right:], tgb.data[tgb.gapEnd:])
+	tgb.data = data
+	tgb.gapEnd = size - right
+}
+
+// RuneAt returns the rune at offset, or 0 outside the buffer
+func (tgb *TextGapBuffer) RuneAt(offset int) rune {
+	if offset < 0 || offset >= tgb.Len() {
+		return 0
+	}
+	return tgb.at(offset)
+}
+
+// Find returns the first match of re starting at or after from as [start,
+// end) offsets, or -1, -1 if there is none. Matching goes a line at a
+// time, so ^ and $ anchor to line boundaries and no match spans lines.
+func (tgb *TextGapBuffer) Find(re *regexp.Regexp, from int) (int, int) {
+	if from < 0 || from > tgb.Len() {
+		return -1, -1
+	}
+	start, _ := tgb.LineBounds(from)
+	for {
+		next, matches := tgb.lineMatches(re, start)
+		for _, m := range matches {
+			if m[0] >= from {
+				return m[0], m[1]
+			}
+		}
+		if next <= start || next >= tgb.Len() {
+			return -1, -1
+		}
+		start = next
+	}
+}
+
+// FindBefore returns the last match of re starting before the offset
+// before, line by line like Find, or -1, -1 if there is none
+func (tgb *TextGapBuffer) FindBefore(re *regexp.Regexp, before int) (int, int) {
+	if before <= 0 || before > tgb.Len() {
+		return -1, -1
+	}
+	start, _ := tgb.LineBounds(before - 1)
+	for {
+		_, matches := tgb.lineMatches(re, start)
+		for k := len(matches) - 1; k >= 0; k-- {
+			if matches[k][0] < before {
+				return matches[k][0], matches[k][1]
+			}
+		}
+		if start == 0 {
+			return -1, -1
+		}
+		start, _ = tgb.LineBounds(start - 1)
+	}
+}
+
+// lineMatches finds every match of re in the line starting at offset start
+// as buffer offsets, and the offset of the next line
+func (tgb *TextGapBuffer) lineMatches(re *regexp.Regexp, start int) (int, [][2]int) {
+	_, next := tgb.LineBounds(start)
+	end := next
+	if end > start && tgb.at(end-1) == '\n' {
+		end--
+	}
+	line := tgb.Slice(start, end)
+	var matches [][2]int
+	for _, m := range re.FindAllStringIndex(line, -1) {
+		from := start + utf8.RuneCountInString(line[:m[0]])
+		matches = append(matches, [2]int{from, from + utf8.RuneCountInString(line[m[0]:m[1]])})
+	}
+	return next, matches
+}
+
+// at returns the rune at offset as if the gap did not exist
+func (tgb *TextGapBuffer) at(offset int) rune {
+	if offset >= tgb.gapStart {
+		offset += tgb.gapEnd - tgb.gapStart
+	}
+	return tgb.data[offset]
+}
+
+// Offset converts a line and column into a rune offset. Columns past the end
+// of the line are clamped to the end of the line.
+func (tgb *TextGapBuffer) Offset(y int, x int) (int, error) {
+	if y < 0 || x < 0 {
+		return 0, errors.New("position out of range")
+	}
+	n := tgb.Len()
+	starts := tgb.lineStarts()
+	var i int
+	switch {
+	case y < len(starts):
+		i = starts[y]
+	case y == len(starts) && n > 0 && tgb.at(n-1) == '\n':
+		i = n // the empty line after a trailing newline
+	default:
+		return 0, errors.New("line out of range")
+	}
+	for col := 0; col < x && i < n && tgb.at(i) != '\n'; col++ {
+		i++
+	}
+	return i, nil
+}
+
+func (tgb *TextGapBuffer) ChangeCursorPosition(y int, x int) error {
+	offset, err := tgb.Offset(y, x)
+	if err != nil {
+		return err
+	}
+	return tgb.MoveGapTo(offset)
+}
+
+// LineBounds returns the offset of the first rune on the line containing
+// offset and the offset just past that line's newline
+func (tgb *TextGapBuffer) LineBounds(offset int) (int, int) {
+	start, end := offset, offset
+	for start > 0 && tgb.at(start-1) != '\n' {
+		start--
+	}
+	for end < tgb.Len() && tgb.at(end) != '\n' {
+		end++
+	}
+	if end < tgb.Len() {
+		end++
+	}
+	return start, end
+}
+
+// lineStart is the offset of the first rune on the cursor's line
+func (tgb *TextGapBuffer) lineStart() int {
+	start := tgb.gapStart
+	for start > 0 && tgb.at(start-1) != '\n' {
+		start--
+	}
+	return start
+}
+
+// LineLength is the number of runes on the line the cursor is on, not
+// counting the newline
+func (tgb *TextGapBuffer) LineLength() int {
+	start := tgb.lineStart()
+	end := tgb.gapStart
+	for end < tgb.Len() && tgb.at(end) != '\n' {
+		end++
+	}
+	return end - start
+}
+
+// LineCount is the number of lines, a trailing newline does not start a
+// new line of its own
+func (tgb *TextGapBuffer) LineCount() int {
+	return len(tgb.lineStarts())
+}
+
+// LineStart is the offset of the first rune on line y, lines past the end
+// are clamped to the last line
+func (tgb *TextGapBuffer) LineStart(y int) int {
+	starts := tgb.lineStarts()
+	return starts[max(min(y, len(starts)-1), 0)]
+}
+
+// Indent is the number of leading spaces and tabs on the cursor's line
+func (tgb *TextGapBuffer) Indent() int {
+	n := 0
+	for i := tgb.lineStart(); i < tgb.Len(); i++ {
+		if r := tgb.at(i); r != ' ' && r != '\t' {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// CursorColumn is the screen column of the cursor, accounting for runes
+// that take up zero or two cells and tabs that reach the next multiple of
+// tabstop
+func (tgb *TextGapBuffer) CursorColumn(tabstop int) int {
+	col := 0
+	for i := tgb.lineStart(); i < tgb.gapStart; i++ {
+		col += CellWidth(tgb.at(i), col, tabstop)
+	}
+	return col
+}
+
+// Cursor is the offset of the insertion point
+func (tgb *TextGapBuffer) Cursor() int {
+	return tgb.gapStart
+}
+
+// SetCursor moves the insertion point to offset
+func (tgb *TextGapBuffer) SetCursor(offset int) error {
+	return tgb.MoveGapTo(offset)
+}
+
+// CursorYX is the line and rune column of the insertion point
+func (tgb *TextGapBuffer) CursorYX() (int, int) {
+	starts := tgb.lineStarts()
+	if n := tgb.Len(); tgb.gapStart == n && n > 0 && tgb.at(n-1) == '\n' {
+		return len(starts), 0 // after a trailing newline
+	}
+	y, found := slices.BinarySearch(starts, tgb.gapStart)
+	if !found {
+		y--
+	}
+	return y, tgb.gapStart - starts[y]
+}
+
+// Select marks the runes in [from, to) as selected, an empty range clears
+// the selection
+func (tgb *TextGapBuffer) Select(from int, to int) error {
+	if from > to {
+		return errors.New("selection start is after its end")
+	}
+	if from < 0 || to > tgb.Len() {
+		return errors.New("selection out of range")
+	}
+	tgb.selFrom, tgb.selTo = from, to
+	return nil
+}
+
+func (tgb *TextGapBuffer) Selection() (int, int) {
+	return tgb.selFrom, tgb.selTo
+}
+
+func (tgb *TextGapBuffer) SelectedText() string {
+	return tgb.Slice(tgb.selFrom, tgb.selTo)
+}
+
+// Slice returns the text in [from, to)
+func (tgb *TextGapBuffer) Slice(from int, to int) string {
+	out := make([]rune, 0, to-from)
+	for i := from; i < to; i++ {
+		out = append(out, tgb.at(i))
+	}
+	return string(out)
+}
+
+// CellWidth is the number of terminal cells r occupies when drawn at column
+// col, which only matters for tabs
+func CellWidth(r rune, col int, tabstop int) int {
+	if r == '\t' {
+		return tabstop - col%tabstop
+	}
+	return RuneWidth(r)
+}
+
+// ColumnRune is the index of the rune in text covering screen column col,
+// or len(text) when the line is narrower
+func ColumnRune(text []rune, col int, tabstop int) int {
+	c := 0
+	for i, r := range text {
+		c += CellWidth(r, c, tabstop)
+		if c > col {
+			return i
+		}
+	}
+	return len(text)
+}
+
+// DisplayWidth is the number of screen columns text takes up
+func DisplayWidth(text []rune, tabstop int) int {
+	col := 0
+	for _, r := range text {
+		col += CellWidth(r, col, tabstop)
+	}
+	return col
+}
+
+// RuneWidth is the number of terminal cells r occupies
+func RuneWidth(r rune) int {
+	switch {
+	case IsControl(r):
+		return len(ControlText(r))
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r >= 0x1100 && r <= 0x115f, // hangul jamo
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f, // cjk
+		r >= 0xac00 && r <= 0xd7a3,                // hangul syllables
+		r >= 0xf900 && r <= 0xfaff,                // cjk compatibility
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60, // fullwidth forms
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f, // emoji
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
+
+// IsControl reports whether r is a control character other than tab and
+// newline, which the terminal would act on rather than show
+func IsControl(r rune) bool {
+	return r != '\t' && r != '\n' && (r < 0x20 || r >= 0x7f && r < 0xa0)
+}
+
+// ControlText is how a control character is drawn, its code in hex as
+// <XX>
+func ControlText(r rune) string {
+	return fmt.Sprintf("<%02X>", r)
+}
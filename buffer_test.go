@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// typeDelete types n runes one at a time and backspaces over half of them,
+// the way INSERT mode drives the buffer
+func typeDelete(b *testing.B, n int) {
+	for i := 0; i < b.N; i++ {
+		buf, _ := NewTextGapBuffer("")
+		for j := 0; j < n; j++ {
+			buf.WriteChar('x')
+		}
+		for j := 0; j < n/2; j++ {
+			buf.Delete()
+		}
+		if buf.Len() != n-n/2 {
+			b.Fatalf("Len() = %d, want %d", buf.Len(), n-n/2)
+		}
+	}
+}
+
+// BenchmarkTypeDelete should take about ten times as long for each ten
+// times more input, edits staying constant time as the undo step grows
+func BenchmarkTypeDelete(b *testing.B) {
+	for _, n := range []int{10_000, 100_000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) { typeDelete(b, n) })
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"ascii", "abc", "ab"},
+		{"two bytes", "café", "caf"},
+		{"three bytes", "日本", "日"},
+		{"four bytes", "a\U0001F600", "a"},
+		{"mixed", "aé日\U0001F600", "aé日"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, _ := NewTextGapBuffer(tt.text)
+			if err := buf.Delete(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.ReadAll(); got != tt.want {
+				t.Errorf("Delete() left %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteAtStart(t *testing.T) {
+	buf, _ := NewTextGapBuffer("été")
+	buf.MoveGapTo(0)
+	if err := buf.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.ReadAll(); got != "été" {
+		t.Errorf("Delete() at 0 left %q, want the text unchanged", got)
+	}
+}
+
+func TestLineLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		cursor int
+		want   int
+	}{
+		{"empty buffer", "", 0, 0},
+		{"single line", "héllo", 2, 5},
+		{"newline not counted", "héllo\nab", 1, 5},
+		{"line 2 after newline", "ab\ncdé\nx", 3, 3},
+		{"last line without newline", "ab\ncd", 5, 2},
+		{"empty last line", "ab\n", 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, _ := NewTextGapBuffer(tt.text)
+			buf.MoveGapTo(tt.cursor)
+			if got := buf.LineLength(); got != tt.want {
+				t.Errorf("LineLength() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineStartAfterEdits(t *testing.T) {
+	buf, _ := NewTextGapBuffer("ab\ncd\nef")
+	check := func(want ...int) {
+		t.Helper()
+		if got := buf.LineCount(); got != len(want) {
+			t.Fatalf("%q: LineCount() = %d, want %d", buf.ReadAll(), got, len(want))
+		}
+		for y, start := range want {
+			if got := buf.LineStart(y); got != start {
+				t.Errorf("%q: LineStart(%d) = %d, want %d", buf.ReadAll(), y, got, start)
+			}
+		}
+	}
+	check(0, 3, 6)
+	buf.SetCursor(4)
+	buf.Write("x\ny")
+	check(0, 3, 6, 9)
+	buf.DeleteRange(0, 3)
+	check(0, 3, 6)
+	buf.DeleteRange(3, buf.Len())
+	check(0)
+	buf.SetCursor(buf.Len())
+	buf.Write("z")
+	check(0, 3)
+	if got := buf.LineStart(7); got != 3 {
+		t.Errorf("LineStart past the end = %d, want the last line's 3", got)
+	}
+}
+
+func TestCursorYXAndOffset(t *testing.T) {
+	for _, text := range []string{"", "a", "ab\ncd", "ab\ncd\n", "\n\n", "é\n日本\n\nx"} {
+		buf, _ := NewTextGapBuffer(text)
+		runes := []rune(text)
+		y, x := 0, 0
+		for offset := 0; offset <= len(runes); offset++ {
+			buf.SetCursor(offset)
+			if gy, gx := buf.CursorYX(); gy != y || gx != x {
+				t.Errorf("%q at %d: CursorYX() = %d, %d, want %d, %d", text, offset, gy, gx, y, x)
+			}
+			if got, err := buf.Offset(y, x); err != nil || got != offset {
+				t.Errorf("%q: Offset(%d, %d) = %d, %v, want %d", text, y, x, got, err, offset)
+			}
+			if offset < len(runes) && runes[offset] == '\n' {
+				y, x = y+1, 0
+			} else {
+				x++
+			}
+		}
+		if _, err := buf.Offset(y+1, 0); err == nil {
+			t.Errorf("%q: Offset(%d, 0) past the last line did not fail", text, y+1)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// commentstring used for file types without a known comment prefix, %s
+// stands for the commented text like in vim
+const defaultCommentString = "# %s"
+
+// line comment prefixes by file extension
+var commentPrefixes = map[string]string{
+	".go": "//", ".c": "//", ".h": "//", ".cc": "//", ".cpp": "//",
+	".java": "//", ".js": "//", ".ts": "//", ".rs": "//", ".swift": "//",
+	".sh": "#", ".bash": "#", ".py": "#", ".rb": "#", ".pl": "#",
+	".yaml": "#", ".yml": "#", ".toml": "#", ".conf": "#", ".mk": "#",
+	".lua": "--", ".sql": "--", ".hs": "--", ".vim": "\"", ".lisp": ";",
+}
+
+// CommentPrefix is the line comment prefix for the file being edited, from
+// its extension or else from :set commentstring
+func CommentPrefix(s *State) string {
+	base := filepath.Base(s.filename)
+	if prefix, ok := commentPrefixes[filepath.Ext(base)]; ok {
+		return prefix
+	}
+	if base == "Makefile" || base == "Dockerfile" {
+		return "#"
+	}
+	prefix, _, _ := strings.Cut(s.commentstring, "%s")
+	return strings.TrimSpace(prefix)
+}
+
+// ToggleComment comments out lines from to to, or uncomments them when all
+// of their non-blank lines are already comments. The prefix goes at the
+// smallest indent among the lines and uncommenting also removes one space
+// after it.
+func ToggleComment(s *State, from int, to int) error {
+	prefix := CommentPrefix(s)
+	if prefix == "" {
+		return nil
+	}
+	to = min(to, s.buf.LineCount()-1)
+	indent, commented := -1, true
+	for y := from; y <= to; y++ {
+		text := string(LineText(s.buf, y))
+		trimmed := strings.TrimLeft(text, " \t")
+		if trimmed == "" {
+			continue
+		}
+		n := len([]rune(text)) - len([]rune(trimmed))
+		if indent == -1 || n < indent {
+			indent = n
+		}
+		commented = commented && strings.HasPrefix(trimmed, prefix)
+	}
+	if indent == -1 {
+		return nil // only blank lines
+	}
+	for y := from; y <= to; y++ {
+		text := LineText(s.buf, y)
+		start := s.buf.LineStart(y)
+		trimmed := strings.TrimLeft(string(text), " \t")
+		if trimmed == "" {
+			continue
+		}
+		var err error
+		if commented {
+			at := start + len(text) - len([]rune(trimmed))
+			n := len([]rune(prefix))
+			if strings.HasPrefix(trimmed[len(prefix):], " ") {
+				n++
+			}
+			err = s.buf.ReplaceRange(at, at+n, "")
+		} else {
+			err = s.buf.ReplaceRange(start+indent, start+indent, prefix+" ")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	MoveToLine(s, from)
+	return nil
+}
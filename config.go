@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigPath is the config file read at startup, ~/.config/gotext/config or
+// else ~/.gotextrc, or "" when there is neither
+func ConfigPath() string {
+	var paths []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "gotext", "config"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".gotextrc"))
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// expandHome replaces a leading ~ in path with the home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + path[1:]
+		}
+	}
+	return path
+}
+
+// Source runs the file at path as ex commands, one per line, skipping
+// blank lines and " comments. A bad line doesn't stop the rest: each error
+// goes to the message history and a single one is returned for them all.
+func Source(s *State, path string) error {
+	f, err := os.Open(expandHome(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var errs []error
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "\"") {
+			continue
+		}
+		if err := RunCommand(s, strings.TrimPrefix(line, ":")); err != nil {
+			errs = append(errs, fmt.Errorf("%s line %d: %w", path, n, err))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 1 {
+		for _, err := range errs {
+			Error(s, err)
+		}
+		return fmt.Errorf("%d errors in %s, see :messages", len(errs), path)
+	}
+	return errors.Join(errs...)
+}
+
+// SourceCommand is :source, which runs path or, without one, the config
+// file read at startup again
+func SourceCommand(s *State, path string) error {
+	if path == "" {
+		path = s.config
+	}
+	if path == "" {
+		return errors.New("No config file")
+	}
+	return Source(s, path)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+// Document is an open file: its text and what goes with it, and where the
+// cursor and view were the last time it was shown
+type Document struct {
+	id       int // buffer number shown by :ls
+	buf      TextBuffer
+	filename string
+	stamp    FileStamp // of the file when it was last read or written
+	swap     *Swap
+	format   Format // how the file is stored on disk
+	syntax   SyntaxCache
+	lastY    int
+	lastX    int
+	lastTop  int
+	lastLeft int
+}
+
+// NewDocument wraps buf, read from filename, in a document with the next
+// buffer number
+func NewDocument(s *State, buf TextBuffer, filename string) *Document {
+	s.lastID++
+	return &Document{id: s.lastID, buf: buf, filename: filename, stamp: Stamp(filename), format: defaultFormat}
+}
+
+// FindDocument is the open document for the file at path, or nil
+func FindDocument(s *State, path string) *Document {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	for _, d := range s.documents {
+		if other, err := filepath.Abs(d.filename); err == nil && d.filename != "" && other == abs {
+			return d
+		}
+	}
+	return nil
+}
+
+// SwitchTo shows document d, remembering the cursor and view of the one
+// being left so they come back when it is shown again
+func SwitchTo(s *State, d *Document) {
+	if d == s.Document {
+		return
+	}
+	UpdateSwap(s, true)
+	s.lastY, s.lastX, s.lastTop, s.lastLeft = s.y, s.x, s.topLine, s.leftCol
+	s.Document = d
+	s.y, s.x, s.topLine, s.leftCol = d.lastY, d.lastX, d.lastTop, d.lastLeft
+	s.anchor = 0
+}
+
+// OpenDocument switches to the file at path, reading it into a new buffer
+// in encoding enc unless one already has it
+func OpenDocument(s *State, path string, enc string) error {
+	if d := FindDocument(s, path); d != nil {
+		SwitchTo(s, d)
+		return nil
+	}
+	buf, f, err := OpenFile(path, enc)
+	if err != nil {
+		return err
+	}
+	d := NewDocument(s, buf, path)
+	d.format = f
+	buf.SetReadOnly(!Writable(path) || f.binary)
+	s.documents = append(s.documents, d)
+	SwitchTo(s, d)
+	Info(s, fmt.Sprintf("%q %dL, %dB%s", path, buf.LineCount(), f.Size(buf.ReadAll()), f.Note()))
+	Error(s, f.Warning())
+	if err := LoadUndo(s); err != nil {
+		return err
+	}
+	return CheckSwap(s)
+}
+
+// NextDocument switches n buffers on in the list, or back when n is
+// negative, wrapping around at either end like :bnext and :bprev
+func NextDocument(s *State, n int) error {
+	k := len(s.documents)
+	for i, d := range s.documents {
+		if d == s.Document {
+			SwitchTo(s, s.documents[((i+n)%k+k)%k])
+			return nil
+		}
+	}
+	return nil
+}
+
+// GotoDocument switches to buffer number arg, like :b N
+func GotoDocument(s *State, arg string) error {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return errors.New("Invalid buffer number: " + arg)
+	}
+	for _, d := range s.documents {
+		if d.id == id {
+			SwitchTo(s, d)
+			return nil
+		}
+	}
+	return fmt.Errorf("Buffer %d does not exist", id)
+}
+
+// ListDocuments shows the open buffers in the pager with their numbers,
+// names and whether they are modified, % marking the current one
+func ListDocuments(s *State) error {
+	s.pager = nil
+	for _, d := range s.documents {
+		flag, line := 'h', d.lastY
+		if d == s.Document {
+			flag, line = '%', s.y
+		}
+		modified := ' '
+		if d.buf.Modified() {
+			modified = '+'
+		}
+		name := d.filename
+		if name == "" {
+			name = "[No Name]"
+		}
+		s.pager = append(s.pager, Message{text: fmt.Sprintf("%3d %c %c %-30q line %d", d.id, flag, modified, name, line+1)})
+	}
+	s.pagerTop = 0
+	s.status = PAGER
+	return nil
+}
+
+// ModifiedDocument is an open buffer other than the current one with
+// changes that were not written, or nil
+func ModifiedDocument(s *State) *Document {
+	for _, d := range s.documents {
+		if d != s.Document && d.buf.Modified() {
+			return d
+		}
+	}
+	return nil
+}
+
+// CloseDocuments stops the swap writers of every buffer when the editor
+// exits, removing their swap files
+func CloseDocuments(s *State) {
+	for _, d := range s.documents {
+		if d.swap != nil {
+			d.swap.Close()
+		}
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DeleteLines removes the whole lines in [from, to) into the register and
+// leaves the cursor at the start of the line that moved into their place
+func DeleteLines(s *State, from int, to int) error {
+	r := Register{text: s.buf.Slice(from, to), linewise: true}
+	if !strings.HasSuffix(r.text, "\n") {
+		// the last line has no newline of its own, take the one before it
+		r.text += "\n"
+		from = max(from-1, 0)
+	}
+	SetRegister(s, r)
+	if err := s.buf.DeleteRange(from, to); err != nil {
+		return err
+	}
+	start, _ := s.buf.LineBounds(s.buf.Cursor())
+	err := s.buf.SetCursor(start)
+	SyncCursor(s)
+	return err
+}
+
+// DeleteLine removes n lines starting at the cursor's line, like dd
+func DeleteLine(s *State, n int) error {
+	from, to := LinesBounds(s.buf, s.buf.Cursor(), n)
+	return DeleteLines(s, from, to)
+}
+
+// LinesBounds is like LineBounds but covers n lines starting at the one
+// containing offset, stopping at the end of the buffer
+func LinesBounds(buf TextBuffer, offset int, n int) (int, int) {
+	from, to := buf.LineBounds(offset)
+	for i := 1; i < n && to < buf.Len(); i++ {
+		_, to = buf.LineBounds(to)
+	}
+	return from, to
+}
+
+// DeleteChars removes up to n characters from the cursor to the end of the
+// line without joining lines, like x
+func DeleteChars(s *State, n int) error {
+	c := s.buf.Cursor()
+	_, end := LineExtent(s.buf, c)
+	end = min(end, c+n)
+	if c >= end {
+		return nil
+	}
+	SetRegister(s, Register{text: s.buf.Slice(c, end)})
+	if err := s.buf.DeleteRange(c, end); err != nil {
+		return err
+	}
+	start, _ := s.buf.LineBounds(c)
+	if c > start && (c == s.buf.Len() || s.buf.Slice(c, c+1) == "\n") {
+		s.buf.SetCursor(c - 1) // deleted the last character, stay on the line
+	}
+	SyncCursor(s)
+	return nil
+}
+
+// Operate applies operator op, d, c or y, to the text in [from, to)
+func Operate(s *State, op byte, from int, to int) error {
+	SetRegister(s, Register{text: s.buf.Slice(from, to)})
+	if op == 'y' {
+		MoveToOffset(s, from)
+		return nil
+	}
+	if err := s.buf.DeleteRange(from, to); err != nil {
+		return err
+	}
+	if op == 'c' {
+		s.status = INSERT
+	} else if start, _ := s.buf.LineBounds(from); from > start && (from == s.buf.Len() || s.buf.RuneAt(from) == '\n') {
+		from-- // deleted to the end of the line, stay on it
+	}
+	MoveToOffset(s, from)
+	return nil
+}
+
+// LineExtent is like LineBounds but stops before the newline
+func LineExtent(buf TextBuffer, offset int) (int, int) {
+	start, end := buf.LineBounds(offset)
+	if end > start && buf.Slice(end-1, end) == "\n" {
+		end--
+	}
+	return start, end
+}
+
+// OpenLine starts a new empty line below the cursor's line, or above it, and
+// enters INSERT mode there
+func OpenLine(s *State, above bool) error {
+	start, end := LineExtent(s.buf, s.buf.Cursor())
+	indent := AutoIndent(s)
+	var err error
+	if above {
+		if err = s.buf.SetCursor(start); err == nil {
+			err = s.buf.Write(indent + "\n")
+		}
+		if err == nil {
+			err = s.buf.SetCursor(start + utf8.RuneCountInString(indent))
+		}
+	} else {
+		if err = s.buf.SetCursor(end); err == nil {
+			err = s.buf.Write("\n" + indent)
+		}
+	}
+	SyncCursor(s)
+	s.status = INSERT
+	return err
+}
+
+// AutoIndent is the leading whitespace of the cursor's line when
+// autoindent is on, to be copied onto a new line
+func AutoIndent(s *State) string {
+	if !s.autoindent {
+		return ""
+	}
+	start, _ := s.buf.LineBounds(s.buf.Cursor())
+	return s.buf.Slice(start, start+s.buf.Indent())
+}
+
+// NewLine breaks the line at the cursor, indenting the new line like the
+// old one with autoindent
+func NewLine(s *State) error {
+	indent := AutoIndent(s)
+	return s.buf.Write("\n" + indent)
+}
+
+// Backspace deletes the character before the cursor. With autoindent, in
+// the indent at the start of a line it deletes back to the previous
+// multiple of shiftwidth instead.
+func Backspace(s *State) error {
+	cursor := s.buf.Cursor()
+	start, _ := s.buf.LineBounds(cursor)
+	before := s.buf.Slice(start, cursor)
+	if !s.autoindent || before == "" || strings.Trim(before, " \t") != "" {
+		return s.buf.Delete()
+	}
+	col := s.buf.CursorColumn(s.tabstop)
+	target := (col - 1) / s.shiftwidth * s.shiftwidth
+	for s.buf.Cursor() > start && s.buf.CursorColumn(s.tabstop) > target {
+		if err := s.buf.Delete(); err != nil {
+			return err
+		}
+	}
+	if col := s.buf.CursorColumn(s.tabstop); col < target {
+		// deleted a tab that reached past the target
+		return s.buf.Write(strings.Repeat(" ", target-col))
+	}
+	return nil
+}
+
+// InsertAt enters INSERT mode with the cursor moved to column x of the
+// current line
+func InsertAt(s *State, x int) error {
+	SyncCursor(s)
+	s.x = x
+	if err := s.buf.ChangeCursorPosition(s.y, s.x); err != nil {
+		return err
+	}
+	SyncCursor(s)
+	s.status = INSERT
+	return nil
+}
+
+// ReplaceChars overwrites n characters from the cursor with r, like r. A
+// newline replaces all of them with a single line break.
+func ReplaceChars(s *State, r rune, n int) error {
+	c := s.buf.Cursor()
+	_, end := LineExtent(s.buf, c)
+	if c+n > end {
+		return nil
+	}
+	text := strings.Repeat(string(r), n)
+	if r == '\n' {
+		text = "\n"
+	}
+	if err := s.buf.ReplaceRange(c, c+n, text); err != nil {
+		return err
+	}
+	if r != '\n' {
+		s.buf.SetCursor(c + n - 1)
+	}
+	SyncCursor(s)
+	return nil
+}
+
+// Overwrite replaces the character under the cursor with r and advances,
+// appending instead at the end of a line
+func Overwrite(s *State, r rune) error {
+	c := s.buf.Cursor()
+	old := s.buf.RuneAt(c)
+	if old == 0 || old == '\n' {
+		s.replaced = append(s.replaced, 0)
+		return s.buf.WriteChar(r)
+	}
+	s.replaced = append(s.replaced, old)
+	return s.buf.ReplaceRange(c, c+1, string(r))
+}
+
+// Unreplace restores the character overwritten by the last keystroke in
+// REPLACE mode
+func Unreplace(s *State) error {
+	n := len(s.replaced)
+	if n == 0 {
+		return nil
+	}
+	old := s.replaced[n-1]
+	s.replaced = s.replaced[:n-1]
+	if old == 0 {
+		return s.buf.Delete()
+	}
+	c := s.buf.Cursor()
+	if err := s.buf.ReplaceRange(c-1, c, string(old)); err != nil {
+		return err
+	}
+	return s.buf.SetCursor(c - 1)
+}
+
+// JoinLines joins n lines starting at the cursor's line into one, like J.
+// The leading whitespace of each joined line becomes a single space.
+func JoinLines(s *State, n int) error {
+	join := -1
+	for i := 1; i < max(n, 2); i++ {
+		start, end := LineExtent(s.buf, s.buf.Cursor())
+		if end == s.buf.Len() {
+			break // last line
+		}
+		next := end + 1
+		for r := s.buf.RuneAt(next); r == ' ' || r == '\t'; r = s.buf.RuneAt(next) {
+			next++
+		}
+		sep := " "
+		if r := s.buf.RuneAt(next); r == '\n' || r == 0 || r == ')' ||
+			(end > start && s.buf.RuneAt(end-1) == ' ') {
+			sep = ""
+		}
+		if err := s.buf.ReplaceRange(end, next, sep); err != nil {
+			return err
+		}
+		join = end
+		s.buf.SetCursor(end)
+	}
+	if join >= 0 {
+		s.buf.SetCursor(join)
+	}
+	SyncCursor(s)
+	return nil
+}
+
+// InsertTab inserts a tab, or with expandtab the spaces up to the next tab
+// stop
+func InsertTab(s *State) error {
+	if !s.expandtab {
+		return s.buf.WriteChar('\t')
+	}
+	col := s.buf.CursorColumn(s.tabstop)
+	return s.buf.Write(strings.Repeat(" ", s.tabstop-col%s.tabstop))
+}
+
+// default columns >> and << shift by
+const defaultShiftwidth = 4
+
+// ShiftLines indents lines from to to by dir shiftwidths, a negative dir
+// dedents but not past column 0. Empty lines are left alone and the cursor
+// ends on the first non-blank of the first line.
+func ShiftLines(s *State, from int, to int, dir int) error {
+	to = min(to, s.buf.LineCount()-1)
+	for y := from; y <= to; y++ {
+		start, end := LineExtent(s.buf, s.buf.LineStart(y))
+		if start == end {
+			continue
+		}
+		text := []rune(s.buf.Slice(start, end))
+		n := 0
+		for n < len(text) && (text[n] == ' ' || text[n] == '\t') {
+			n++
+		}
+		width := max(DisplayWidth(text[:n], s.tabstop)+dir*s.shiftwidth, 0)
+		if err := s.buf.ReplaceRange(start, start+n, IndentText(s, width)); err != nil {
+			return err
+		}
+	}
+	MoveToLine(s, from)
+	return nil
+}
+
+// IndentText is the whitespace for an indent width columns wide, in tabs
+// and spaces or only spaces with expandtab
+func IndentText(s *State, width int) string {
+	if s.expandtab {
+		return strings.Repeat(" ", width)
+	}
+	return strings.Repeat("\t", width/s.tabstop) + strings.Repeat(" ", width%s.tabstop)
+}
+
+// Retab rewrites the leading whitespace of lines from to to for the current
+// tabstop and expandtab, keeping its width. A positive tabstop is taken
+// as the new tabstop, the old one still being used to measure the existing
+// indent. Without bang only indents that contain a tab change, so runs of
+// spaces are turned into tabs only with :retab!. Whitespace after the
+// first non-blank, which may be inside a string, is left alone.
+func Retab(s *State, from int, to int, tabstop int, bang bool) error {
+	old := s.tabstop
+	if tabstop > 0 {
+		s.tabstop = tabstop
+	}
+	y := s.y
+	for line := from; line <= to; line++ {
+		start, end := LineExtent(s.buf, s.buf.LineStart(line))
+		text := []rune(s.buf.Slice(start, end))
+		n := 0
+		for n < len(text) && (text[n] == ' ' || text[n] == '\t') {
+			n++
+		}
+		indent := string(text[:n])
+		if !bang && !strings.Contains(indent, "\t") {
+			continue
+		}
+		if retabbed := IndentText(s, DisplayWidth(text[:n], old)); retabbed != indent {
+			if err := s.buf.ReplaceRange(start, start+n, retabbed); err != nil {
+				return err
+			}
+		}
+	}
+	MoveToLine(s, y)
+	return nil
+}
+
+// TrimTrailing removes spaces and tabs from the ends of lines from to to.
+// The cursor stays where it was in its line, or on the last character left
+// if what it was on is gone.
+func TrimTrailing(s *State, from int, to int) error {
+	y, x := s.y, s.buf.Cursor()-s.buf.LineStart(s.y)
+	for line := from; line <= to; line++ {
+		start, end := LineExtent(s.buf, s.buf.LineStart(line))
+		text := s.buf.Slice(start, end)
+		kept := start + utf8.RuneCountInString(strings.TrimRight(text, " \t"))
+		if kept < end {
+			if err := s.buf.DeleteRange(kept, end); err != nil {
+				return err
+			}
+		}
+	}
+	start, end := LineExtent(s.buf, s.buf.LineStart(y))
+	MoveToOffset(s, min(start+x, max(end-1, start)))
+	return nil
+}
+
+// TrimText is text with the spaces and tabs at the ends of its lines
+// removed, as TrimTrailing leaves them
+func TrimText(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// the number :sort n orders lines by
+var sortNumber = regexp.MustCompile(`-?[0-9]+`)
+
+// SortLines sorts lines from to to like :sort, in reverse order with
+// reverse. The flags are n to order by the first decimal number in each
+// line, lines without one going first, i to ignore case and u to keep only
+// the first of lines that compare equal. Lines that compare equal keep
+// their order and the whole range is replaced in one edit, leaving the
+// cursor on its first line.
+func SortLines(s *State, from int, to int, reverse bool, flags string) error {
+	for _, f := range flags {
+		if !strings.ContainsRune("niu ", f) {
+			return errors.New("Invalid argument: " + flags)
+		}
+	}
+	numeric, fold, unique := strings.Contains(flags, "n"), strings.Contains(flags, "i"), strings.Contains(flags, "u")
+	start := s.buf.LineStart(from)
+	_, end := LineExtent(s.buf, s.buf.LineStart(to))
+	lines := strings.Split(s.buf.Slice(start, end), "\n")
+	compare := func(a string, b string) int {
+		if numeric {
+			m, n := sortNumber.FindString(a), sortNumber.FindString(b)
+			if m == "" || n == "" {
+				return cmp.Compare(len(m), len(n))
+			}
+			x, _ := strconv.Atoi(m)
+			y, _ := strconv.Atoi(n)
+			return cmp.Compare(x, y)
+		}
+		if fold {
+			return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+		}
+		return strings.Compare(a, b)
+	}
+	slices.SortStableFunc(lines, func(a string, b string) int {
+		if reverse {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+	if unique {
+		lines = slices.CompactFunc(lines, func(a string, b string) bool {
+			if numeric && !sortNumber.MatchString(a) {
+				// lines without a number only sort alike
+				return a == b || fold && strings.EqualFold(a, b)
+			}
+			return compare(a, b) == 0
+		})
+	}
+	if err := s.buf.ReplaceRange(start, end, strings.Join(lines, "\n")); err != nil {
+		return err
+	}
+	MoveToLine(s, from)
+	return nil
+}
+
+// ToggleChars switches the case of up to n characters from the cursor to the
+// end of the line and moves past them, like ~
+func ToggleChars(s *State, n int) error {
+	c := s.buf.Cursor()
+	_, end := LineExtent(s.buf, c)
+	end = min(end, c+n)
+	if c >= end {
+		return nil
+	}
+	if err := ToggleCase(s, c, end); err != nil {
+		return err
+	}
+	if end == s.buf.Len() || s.buf.RuneAt(end) == '\n' {
+		end-- // stay on the last character of the line
+	}
+	err := s.buf.SetCursor(end)
+	SyncCursor(s)
+	return err
+}
+
+// ToggleCase switches the case of the letters in [from, to), characters
+// without case are left as they are
+func ToggleCase(s *State, from int, to int) error {
+	text := []rune(s.buf.Slice(from, to))
+	changed := false
+	for i, r := range text {
+		if unicode.IsUpper(r) {
+			text[i] = unicode.ToLower(r)
+		} else if unicode.IsLower(r) {
+			text[i] = unicode.ToUpper(r)
+		} else {
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	c := s.buf.Cursor()
+	if err := s.buf.ReplaceRange(from, to, string(text)); err != nil {
+		return err
+	}
+	return s.buf.SetCursor(c)
+}
+
+// number literals Ctrl-a and Ctrl-x change: hex, or decimal with an optional
+// minus sign
+var numberPattern = regexp.MustCompile(`0[xX][0-9a-fA-F]+|-?[0-9]+`)
+
+// AddNumber adds n to the first number on the line that ends at or after the
+// cursor, keeping the width of leading zeros, and leaves the cursor on its
+// last digit like ctrl-a
+func AddNumber(s *State, n int) error {
+	start, _ := s.buf.LineBounds(s.buf.Cursor())
+	text := LineText(s.buf, s.y)
+	x := Column(s)
+	var loc []int
+	for _, m := range numberPattern.FindAllStringIndex(string(text), -1) {
+		if utf8.RuneCountInString(string(text)[:m[1]]) > x {
+			loc = m
+			break
+		}
+	}
+	if loc == nil {
+		return nil
+	}
+	from := start + utf8.RuneCountInString(string(text)[:loc[0]])
+	old := string(text)[loc[0]:loc[1]]
+	var result string
+	if len(old) > 2 && (old[1] == 'x' || old[1] == 'X') {
+		result = addHex(old, n)
+	} else {
+		result = addDecimal(old, n)
+	}
+	if err := s.buf.ReplaceRange(from, from+len(old), result); err != nil {
+		return err
+	}
+	err := s.buf.SetCursor(from + len(result) - 1)
+	SyncCursor(s)
+	return err
+}
+
+// addDecimal adds n to a decimal literal, padding with zeros to the old
+// number of digits when it had leading zeros
+func addDecimal(old string, n int) string {
+	digits := strings.TrimPrefix(old, "-")
+	v, err := strconv.ParseInt(old, 10, 64)
+	if err != nil {
+		return old // too large to change
+	}
+	v += int64(n)
+	width := 0
+	if len(digits) > 1 && digits[0] == '0' {
+		width = len(digits)
+	}
+	if v < 0 {
+		return fmt.Sprintf("-%0*d", width, -v)
+	}
+	return fmt.Sprintf("%0*d", width, v)
+}
+
+// addHex adds n to a 0x literal as an unsigned number, keeping its width and
+// the case of its letters
+func addHex(old string, n int) string {
+	digits := old[2:]
+	v, err := strconv.ParseUint(digits, 16, 64)
+	if err != nil {
+		return old
+	}
+	v += uint64(n)
+	format := "%0*x"
+	if strings.ToLower(digits) != digits {
+		format = "%0*X"
+	}
+	return old[:2] + fmt.Sprintf(format, len(digits), v)
+}
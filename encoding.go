@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// encodings are the values fileencoding takes. Apart from UTF-8 they are
+// single-byte encodings, latin1 being ISO 8859-1 and cp1252 the Windows
+// code page that adds printable characters in its C1 range.
+var encodings = []string{"utf-8", "latin1", "cp1252"}
+
+// cp1252 maps the bytes 0x80 to 0x9f to what they are in cp1252, 0 where
+// the code page leaves one undefined
+var cp1252 = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// GuessEncoding picks a single-byte encoding for data that is not UTF-8:
+// latin1 when it has no C1 control bytes, which text hardly ever does, or
+// else cp1252 if it is defined for all of them. It returns "" when neither
+// fits.
+func GuessEncoding(data string) string {
+	enc := "latin1"
+	for i := 0; i < len(data); i++ {
+		if b := data[i]; b >= 0x80 && b < 0xa0 {
+			if cp1252[b-0x80] == 0 {
+				return ""
+			}
+			enc = "cp1252"
+		}
+	}
+	return enc
+}
+
+// DecodeBytes converts data in the single-byte encoding enc to UTF-8
+func DecodeBytes(data string, enc string) string {
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		r := rune(data[i])
+		if enc == "cp1252" && r >= 0x80 && r < 0xa0 && cp1252[r-0x80] != 0 {
+			r = cp1252[r-0x80]
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// EncodeBytes converts text to the encoding enc, writing ? for characters
+// it cannot represent and counting them
+func EncodeBytes(text string, enc string) (string, int) {
+	if enc == "utf-8" {
+		return text, 0
+	}
+	var b strings.Builder
+	bad := 0
+	for _, r := range text {
+		c, ok := encodeRune(r, enc)
+		if !ok {
+			c, bad = '?', bad+1
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), bad
+}
+
+func encodeRune(r rune, enc string) (byte, bool) {
+	if enc == "cp1252" {
+		for i, c := range cp1252 {
+			if c == r && c != 0 {
+				return byte(0x80 + i), true
+			}
+		}
+		if r >= 0x80 && r < 0xa0 {
+			return 0, false
+		}
+	}
+	if r < 0x100 && r != utf8.RuneError {
+		return byte(r), true
+	}
+	return 0, false
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gbin/goncurses"
+)
+
+// HandleCommand edits the : prompt and runs the command on Enter
+func HandleCommand(s *State) error {
+	switch s.key {
+	case 27: // escape
+		s.status = NORMAL
+	case goncurses.KEY_RETURN, goncurses.KEY_ENTER:
+		s.status = NORMAL
+		return RunCommand(s, s.prompt)
+	default:
+		EditPrompt(s)
+	}
+	return nil
+}
+
+// StartCommand opens the : prompt
+func StartCommand(s *State) {
+	s.status = COMMAND
+	s.promptPrefix = ":"
+	s.prompt = ""
+}
+
+// RunCommand parses and executes a command typed at the : prompt
+func RunCommand(s *State, cmd string) error {
+	action, err := ParseCommand(s, cmd)
+	if err != nil {
+		return err
+	}
+	return action(s)
+}
+
+// ParseCommand maps a command line to the action that carries it out,
+// without running it
+func ParseCommand(s *State, cmd string) (func(*State) error, error) {
+	cmd = strings.TrimSpace(cmd)
+	from, to, rest, err := ParseRange(s, cmd)
+	if err != nil {
+		return nil, err
+	}
+	name, args := splitCommand(rest)
+	if name == "" {
+		if rest == cmd {
+			return func(*State) error { return nil }, nil
+		}
+		// a bare line number jumps there, clamped to the buffer
+		return func(s *State) error {
+			Jump(s)
+			MoveToLine(s, to)
+			return nil
+		}, nil
+	}
+	if err := checkRange(s, from, to); err != nil {
+		return nil, err
+	}
+	ranged := rest != cmd
+	switch name {
+	case "w", "w!":
+		force := name == "w!"
+		if strings.HasPrefix(args, "!") && !force {
+			return func(s *State) error { return WriteCommand(s, args[1:]) }, nil
+		}
+		if ranged || args != "" {
+			if !ranged {
+				from, to = 0, s.buf.LineCount()-1
+			}
+			return func(s *State) error { return WriteRange(s, from, to, args, force) }, nil
+		}
+		return func(s *State) error { return Write(s, force) }, nil
+	case "e", "edit":
+		return func(s *State) error { return Edit(s, args, false) }, nil
+	case "e!", "edit!":
+		return func(s *State) error { return Edit(s, args, true) }, nil
+	case "bnext", "bn":
+		return func(s *State) error { return NextDocument(s, 1) }, nil
+	case "bprevious", "bprev", "bp", "bNext", "bN":
+		return func(s *State) error { return NextDocument(s, -1) }, nil
+	case "buffer", "b":
+		return func(s *State) error { return GotoDocument(s, args) }, nil
+	case "split", "sp", "vsplit", "vs":
+		vertical := name[0] == 'v'
+		return func(s *State) error {
+			SplitView(s, vertical)
+			if args != "" {
+				return Edit(s, args, false)
+			}
+			return nil
+		}, nil
+	case "close", "clo":
+		return func(s *State) error {
+			if !CloseView(s) {
+				return errors.New("Cannot close last window")
+			}
+			return nil
+		}, nil
+	case "ls", "buffers", "files":
+		return ListDocuments, nil
+	case "view", "vie":
+		return func(s *State) error {
+			if err := Edit(s, args, false); err != nil {
+				return err
+			}
+			s.buf.SetReadOnly(true)
+			return nil
+		}, nil
+	case "q":
+		return func(s *State) error { return Quit(s, false) }, nil
+	case "q!":
+		return func(s *State) error { return Quit(s, true) }, nil
+	case "wq", "x":
+		return SaveAndQuit, nil
+	case "s":
+		return func(s *State) error { return Substitute(s, from, to, args) }, nil
+	case ">", ">>", ">>>", "<", "<<", "<<<":
+		dir := len(name)
+		if name[0] == '<' {
+			dir = -dir
+		}
+		return func(s *State) error { return ShiftLines(s, from, to, dir) }, nil
+	case "d", "delete":
+		return func(s *State) error {
+			from, _ := s.buf.LineBounds(s.buf.LineStart(from))
+			_, to := s.buf.LineBounds(s.buf.LineStart(to))
+			return DeleteLines(s, from, to)
+		}, nil
+	case "g", "global", "g!", "global!", "v", "vglobal":
+		if !ranged {
+			from, to = 0, s.buf.LineCount()-1
+		}
+		invert := name[0] == 'v' || strings.HasSuffix(name, "!")
+		return func(s *State) error { return Global(s, from, to, args, invert) }, nil
+	case "nohlsearch", "noh":
+		return func(s *State) error {
+			s.nohlsearch = true
+			return nil
+		}, nil
+	case "messages", "mes":
+		return ShowMessages, nil
+	case "undolist", "undol":
+		return ShowUndoTree, nil
+	case "checktime", "checkt":
+		return CheckTime, nil
+	case "stats":
+		return ShowStats, nil
+	case "source", "so":
+		return func(s *State) error { return SourceCommand(s, args) }, nil
+	case "map", "nmap", "nm", "vmap", "vm", "xmap", "xm", "imap", "im":
+		return func(s *State) error { return MapCommand(s, mapModes(name), false, args) }, nil
+	case "noremap", "no", "nnoremap", "nn", "vnoremap", "vn", "xnoremap", "xn", "inoremap", "ino":
+		return func(s *State) error { return MapCommand(s, mapModes(name), true, args) }, nil
+	case "unmap", "unm", "nunmap", "nun", "vunmap", "vu", "xunmap", "xu", "iunmap", "iu":
+		return func(s *State) error { return RemoveMapping(s, mapModes(name), ParseKeys(args)) }, nil
+	case "retab", "ret", "retab!", "ret!":
+		tabstop, err := strconv.Atoi(args)
+		if args != "" && (err != nil || tabstop <= 0) {
+			return nil, errors.New("Invalid argument: " + args)
+		}
+		if !ranged {
+			from, to = 0, s.buf.LineCount()-1
+		}
+		return func(s *State) error {
+			return Retab(s, from, to, tabstop, strings.HasSuffix(name, "!"))
+		}, nil
+	case "trim":
+		if !ranged {
+			from, to = 0, s.buf.LineCount()-1
+		}
+		return func(s *State) error { return TrimTrailing(s, from, to) }, nil
+	case "sort", "sor", "sort!", "sor!":
+		if !ranged {
+			from, to = 0, s.buf.LineCount()-1
+		}
+		reverse := strings.HasSuffix(name, "!")
+		return func(s *State) error { return SortLines(s, from, to, reverse, args) }, nil
+	case "set", "se":
+		return func(s *State) error { return SetOption(s, args) }, nil
+	}
+	return nil, errors.New("Not an editor command: " + cmd)
+}
+
+// splitCommand separates the command name from its arguments. Names are
+// letters optionally followed by !, or a run of > or <, except s which
+// takes its delimiter straight after the name.
+func splitCommand(cmd string) (string, string) {
+	if strings.HasPrefix(cmd, "s") && len(cmd) > 1 && !isLetter(cmd[1]) {
+		return "s", cmd[1:]
+	}
+	if strings.HasPrefix(cmd, ">") || strings.HasPrefix(cmd, "<") {
+		i := 0
+		for i < len(cmd) && cmd[i] == cmd[0] {
+			i++
+		}
+		return cmd[:i], strings.TrimSpace(cmd[i:])
+	}
+	i := 0
+	for i < len(cmd) && isLetter(cmd[i]) {
+		i++
+	}
+	if i < len(cmd) && cmd[i] == '!' {
+		i++
+	}
+	return cmd[:i], strings.TrimSpace(cmd[i:])
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// mapModes is the modes a map command applies to, given by its first
+// letter except for plain map, noremap and unmap which cover NORMAL and
+// VISUAL
+func mapModes(name string) string {
+	switch {
+	case strings.HasPrefix(name, "map"), strings.HasPrefix(name, "no"), strings.HasPrefix(name, "un"):
+		return "nv"
+	case name[0] == 'x':
+		return "v"
+	}
+	return name[:1]
+}
+
+// ParseRange splits a leading line range such as 3,10, .,$-1, '<,'> or %
+// off cmd and returns the 0-based first and last lines. Without a range
+// both are the current line, as is a first line left out before the comma.
+// The lines are not checked against the buffer.
+func ParseRange(s *State, cmd string) (int, int, string, error) {
+	if strings.HasPrefix(cmd, "%") {
+		return 0, s.buf.LineCount() - 1, cmd[1:], nil
+	}
+	from, cmd, ok, err := parseAddress(s, cmd)
+	if err != nil || !ok && !strings.HasPrefix(cmd, ",") {
+		return s.y, s.y, cmd, err
+	} else if !ok {
+		from = s.y
+	}
+	to := from
+	if strings.HasPrefix(cmd, ",") {
+		if to, cmd, ok, err = parseAddress(s, cmd[1:]); err != nil {
+			return 0, 0, cmd, err
+		} else if !ok {
+			return 0, 0, cmd, errors.New("Invalid range")
+		}
+	}
+	return from, to, cmd, nil
+}
+
+// checkRange reports lines outside the buffer and ranges given backwards
+func checkRange(s *State, from int, to int) error {
+	if from < 0 || to > s.buf.LineCount()-1 {
+		return errors.New("Invalid range")
+	}
+	if from > to {
+		return errors.New("Backwards range given")
+	}
+	return nil
+}
+
+// parseAddress reads a line from the start of cmd: a line number, . for the
+// current line, $ for the last one or 'x for the line of mark x, such as '<
+// and '> around the last visual selection. Any +N or -N after it is added,
+// a bare + or - counting one, and on its own is taken from the current
+// line.
+func parseAddress(s *State, cmd string) (int, string, bool, error) {
+	var y int
+	switch {
+	case strings.HasPrefix(cmd, "."):
+		y, cmd = s.y, cmd[1:]
+	case strings.HasPrefix(cmd, "$"):
+		y, cmd = s.buf.LineCount()-1, cmd[1:]
+	case strings.HasPrefix(cmd, "'") && len(cmd) > 1:
+		offset, ok := s.buf.Mark(rune(cmd[1]))
+		if !ok {
+			return 0, cmd, false, errors.New("E20: Mark not set")
+		}
+		y = strings.Count(s.buf.Slice(0, offset), "\n")
+		cmd = cmd[2:]
+	case strings.HasPrefix(cmd, "+"), strings.HasPrefix(cmd, "-"):
+		y = s.y
+	default:
+		n, rest := leadingNumber(cmd)
+		if rest == cmd {
+			return 0, cmd, false, nil
+		}
+		y, cmd = n-1, rest
+	}
+	for strings.HasPrefix(cmd, "+") || strings.HasPrefix(cmd, "-") {
+		sign := 1
+		if cmd[0] == '-' {
+			sign = -1
+		}
+		n, rest := leadingNumber(cmd[1:])
+		if rest == cmd[1:] {
+			n = 1
+		}
+		y, cmd = y+sign*n, rest
+	}
+	return y, cmd, true, nil
+}
+
+// leadingNumber reads the digits at the start of cmd, returning cmd as it
+// is when there are none
+func leadingNumber(cmd string) (int, string) {
+	i := 0
+	for i < len(cmd) && cmd[i] >= '0' && cmd[i] <= '9' {
+		i++
+	}
+	n, _ := strconv.Atoi(cmd[:i])
+	return n, cmd[i:]
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		from int
+		to   int
+		rest string
+	}{
+		{"s/a/b/", 4, 4, "s/a/b/"},
+		{".d", 4, 4, "d"},
+		{"$d", 9, 9, "d"},
+		{"%s", 0, 9, "s"},
+		{"3", 2, 2, ""},
+		{"2,5d", 1, 4, "d"},
+		{"'<,'>s", 1, 3, "s"},
+		{".,.+3d", 4, 7, "d"},
+		{"$-1d", 8, 8, "d"},
+		{"+d", 5, 5, "d"},
+		{"-d", 3, 3, "d"},
+		{"-,+d", 3, 5, "d"},
+		{".+2-1", 5, 5, ""},
+		{",7d", 4, 6, "d"},
+		{",$", 4, 9, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			s := newTestState(t, strings.Repeat("line\n", 10))
+			s.buf.SetMark('<', s.buf.LineStart(1))
+			s.buf.SetMark('>', s.buf.LineStart(3)+2)
+			s.buf.ChangeCursorPosition(4, 0)
+			s.y = 4
+			from, to, rest, err := ParseRange(s, tt.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if from != tt.from || to != tt.to || rest != tt.rest {
+				t.Errorf("ParseRange(%q) = %d, %d, %q, want %d, %d, %q", tt.cmd, from, to, rest, tt.from, tt.to, tt.rest)
+			}
+		})
+	}
+}
+
+func TestCommandWithoutFirstLine(t *testing.T) {
+	s := newTestState(t, strings.Repeat("line\n", 10))
+	s.buf.ChangeCursorPosition(1, 0)
+	s.y = 1
+	action, err := ParseCommand(s, ",5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := action(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.y != 4 {
+		t.Errorf(":,5 left the cursor on line %d, want 4", s.y)
+	}
+}
+
+func TestParseRangeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  string
+		want string
+	}{
+		{"unset mark", "'a,.d", "E20: Mark not set"},
+		{"unset mark after comma", ".,'bd", "E20: Mark not set"},
+		{"missing end", "2,d", "Invalid range"},
+		{"backwards", "5,2d", "Backwards range given"},
+		{"past the last line", "3,11d", "Invalid range"},
+		{"above the first line", "-6d", "Invalid range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t, strings.Repeat("line\n", 10))
+			s.buf.ChangeCursorPosition(4, 0)
+			s.y = 4
+			from, to, _, err := ParseRange(s, tt.cmd)
+			if err == nil {
+				err = checkRange(s, from, to)
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("ParseRange(%q) gave error %v, want %q", tt.cmd, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OpenFile reads the file at path into a new buffer and tells how it was
+// stored, in encoding enc or the one DecodeText picks when enc is "". A
+// file that does not exist yet gives an empty buffer, like vim does for
+// new files.
+func OpenFile(path string, enc string) (*TextGapBuffer, Format, error) {
+	text, f, err := ReadText(path, enc)
+	if err != nil {
+		return nil, f, err
+	}
+	buf, err := NewTextGapBuffer(text)
+	return buf, f, err
+}
+
+// ReadText reads the file at path in encoding enc as buffer text, empty
+// when there is no such file
+func ReadText(path string, enc string) (string, Format, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", defaultFormat, nil
+	} else if err != nil {
+		return "", defaultFormat, err
+	}
+	text, f := DecodeText(data, enc)
+	return text, f, nil
+}
+
+// WriteFile atomically replaces the file at path with text by writing a
+// temporary file next to it and renaming it into place
+func WriteFile(path string, text string) (int, error) {
+	perm := fs.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	n, err := tmp.WriteString(text)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
+
+// BackupPath is where the old contents of the file at path are kept, path~
+// or the same name in dir when there is one
+func BackupPath(path string, dir string) string {
+	if dir == "" {
+		return path + "~"
+	}
+	return filepath.Join(dir, filepath.Base(path)+"~")
+}
+
+// Backup copies the file at path to its backup with the same permissions
+// before it is overwritten, a file that does not exist yet needs none
+func Backup(path string, dir string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	backup := BackupPath(path, dir)
+	if _, err := WriteFile(backup, string(data)); err != nil {
+		return err
+	}
+	return os.Chmod(backup, info.Mode().Perm())
+}
+
+// Writable reports whether the file at path can be written, which a file
+// that does not exist yet can
+func Writable(path string) bool {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return errors.Is(err, fs.ErrNotExist)
+	}
+	f.Close()
+	return true
+}
+
+// FileStamp is the modification time and size of a file, to tell whether
+// something else changed it
+type FileStamp struct {
+	mtime time.Time
+	size  int64
+}
+
+// Stamp reads the stamp of the file at path, the zero stamp when it does
+// not exist
+func Stamp(path string) FileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileStamp{}
+	}
+	return FileStamp{mtime: info.ModTime(), size: info.Size()}
+}
+
+// ChangedOnDisk reports whether the file being edited changed since it was
+// last read or written
+func ChangedOnDisk(s *State) bool {
+	if s.filename == "" {
+		return false
+	}
+	now := Stamp(s.filename)
+	return !now.mtime.Equal(s.stamp.mtime) || now.size != s.stamp.size
+}
+
+// Save writes the buffer to the file associated with the state
+func Save(s *State) error {
+	return Write(s, false)
+}
+
+// Write writes the buffer to its file, refusing to overwrite changes made
+// to the file by something else since it was read unless force is set
+func Write(s *State, force bool) error {
+	if s.filename == "" && s.stdout && s.Document == s.documents[0] {
+		s.output, _ = EncodeText(FixEndOfLine(s.buf.ReadAll(), s.format, s.fixeol), s.format)
+		s.buf.MarkSaved()
+		Info(s, fmt.Sprintf("%d bytes written to stdout on exit", len(s.output)))
+		return nil
+	}
+	if s.filename == "" {
+		return errors.New("No file name")
+	}
+	if !force && s.buf.ReadOnly() {
+		return errors.New(ErrReadOnly.Error() + " (add ! to override)")
+	}
+	if !force && ChangedOnDisk(s) {
+		return errors.New("WARNING: The file has been changed since reading it!!! (add ! to override)")
+	}
+	// nothing is changed, in the buffer or on disk, for a write that will
+	// be refused, so with trimtrailingws the lines are trimmed in the text
+	// written and only in the buffer once the file has it
+	text := s.buf.ReadAll()
+	if s.trimws {
+		text = TrimText(text)
+	}
+	text = FixEndOfLine(text, s.format, s.fixeol)
+	data, bad := EncodeText(text, s.format)
+	if bad > 0 {
+		return fmt.Errorf("Conversion to %s failed for %d characters, not written", s.format.encoding, bad)
+	}
+	if s.backup {
+		if err := Backup(s.filename, s.backupdir); err != nil {
+			return errors.New("Cannot write backup file, not written: " + err.Error())
+		}
+	}
+	n, err := WriteFile(s.filename, data)
+	if err != nil {
+		return err
+	}
+	if s.trimws {
+		if err := TrimTrailing(s, 0, s.buf.LineCount()-1); err != nil {
+			return err
+		}
+	}
+	s.buf.MarkSaved()
+	s.stamp = Stamp(s.filename)
+	s.format.mixed, s.format.invalid = false, false
+	s.format.noeol = text != "" && !strings.HasSuffix(text, "\n")
+	SwapSaved(s)
+	if s.Document == s.documents[0] {
+		s.output = data
+	}
+	s.written = time.Now()
+	Info(s, fmt.Sprintf("%d bytes written", n))
+	return SaveUndo(s, text)
+}
+
+// WriteRange writes lines from to to into the file at path, or the
+// buffer's own file when path is empty, like :10,20w file. The buffer keeps
+// its name and stays modified. Overwriting another file that exists, or
+// the buffer's file with only part of it, takes force.
+func WriteRange(s *State, from int, to int, path string, force bool) error {
+	path = expandHome(path)
+	if path == "" {
+		path = s.filename
+	}
+	if path == "" {
+		return errors.New("No file name")
+	}
+	if !force && path == s.filename && (from > 0 || to < s.buf.LineCount()-1) {
+		return errors.New("E140: Use ! to write partial buffer")
+	}
+	if _, err := os.Stat(path); err == nil && !force && path != s.filename {
+		return errors.New("E13: File exists (add ! to override)")
+	}
+	start := s.buf.LineStart(from)
+	_, end := s.buf.LineBounds(s.buf.LineStart(to))
+	data, bad := EncodeText(FixEndOfLine(s.buf.Slice(start, end), s.format, s.fixeol), s.format)
+	if bad > 0 {
+		return fmt.Errorf("Conversion to %s failed for %d characters, not written", s.format.encoding, bad)
+	}
+	n, err := WriteFile(path, data)
+	if err != nil {
+		return err
+	}
+	if path == s.filename {
+		s.stamp = Stamp(s.filename)
+	}
+	Info(s, fmt.Sprintf("%d lines, %d bytes written", to-from+1, n))
+	return nil
+}
+
+// default time without a key before autosave writes the buffer
+const defaultAutoSaveTime = 10 * time.Second
+
+// AutoSaveDue is how long until autosave should write the buffer, and
+// whether it is waiting to at all. It only does in NORMAL mode with no
+// command half typed, for a modified buffer that has a file name.
+func AutoSaveDue(s *State) (time.Duration, bool) {
+	if !s.autosave || s.filename == "" || !s.buf.Modified() ||
+		s.status != NORMAL || s.pending != "" || s.count != 0 || s.regName != 0 {
+		return 0, false
+	}
+	return max(s.autosavetime-time.Since(s.lastKey), 0), true
+}
+
+// AutoSave writes the buffer once it has been left alone for autosavetime
+func AutoSave(s *State) error {
+	if due, ok := AutoSaveDue(s); !ok || due > 0 {
+		return nil
+	}
+	err := Save(s)
+	if err != nil {
+		s.lastKey = time.Now() // wait as long again before retrying
+	}
+	return err
+}
+
+// CheckTime warns when the file changed on disk since it was read, and
+// offers to read it again when the buffer has no changes of its own
+func CheckTime(s *State) error {
+	if !ChangedOnDisk(s) {
+		return nil
+	}
+	if s.buf.Modified() {
+		return errors.New("WARNING: The file has been changed since editing started")
+	}
+	Ask(s, "File changed on disk, reload it? (y/n)", func(s *State) error {
+		if s.key == 121 { // y
+			return Reload(s, s.format.encoding)
+		}
+		s.stamp = Stamp(s.filename) // do not ask again for this change
+		return nil
+	})
+	return nil
+}
+
+// Edit switches to the file named in args like :e, keeping the current
+// buffer open, or reads the current file again when there is no name or it
+// is the current file. Reading it again throws away changes that were not
+// written only when force is set. A leading ++enc=name reads the file in
+// that encoding.
+func Edit(s *State, args string, force bool) error {
+	enc, path, err := splitEncoding(args)
+	if err != nil {
+		return err
+	}
+	if path != "" && FindDocument(s, path) != s.Document {
+		return OpenDocument(s, path, enc)
+	}
+	if s.filename == "" {
+		return errors.New("No file name")
+	}
+	if !force && s.buf.Modified() {
+		return errors.New("No write since last change (add ! to override)")
+	}
+	return Reload(s, enc)
+}
+
+// splitEncoding takes a leading ++enc=name off the arguments of :e, giving
+// "" for the encoding when there is none
+func splitEncoding(args string) (string, string, error) {
+	opt, rest, _ := strings.Cut(args, " ")
+	name, enc, ok := strings.Cut(opt, "=")
+	if !ok || name != "++enc" && name != "++encoding" {
+		return "", args, nil
+	}
+	if !slices.Contains(encodings, enc) {
+		return "", "", errors.New("Invalid encoding: " + enc)
+	}
+	return enc, strings.TrimSpace(rest), nil
+}
+
+// Reload reads the file into the buffer again, in encoding enc when it is
+// not "", as a change that can be undone, keeping the cursor on the same
+// line
+func Reload(s *State, enc string) error {
+	text, f, err := ReadText(s.filename, enc)
+	if err != nil {
+		return err
+	}
+	y := s.y
+	readonly := s.buf.ReadOnly()
+	s.buf.SetReadOnly(false)
+	err = s.buf.ReplaceRange(0, s.buf.Len(), text)
+	s.buf.SetReadOnly(readonly || f.binary)
+	if err != nil {
+		return err
+	}
+	s.buf.MarkSaved()
+	s.stamp = Stamp(s.filename)
+	s.format = f
+	MoveToLine(s, y)
+	Info(s, fmt.Sprintf("%q %dL, %dB%s", s.filename, s.buf.LineCount(), f.Size(text), f.Note()))
+	Error(s, f.Warning())
+	return nil
+}
+
+// Quit closes the focused split, or ends the main loop when it is the last
+// one, refusing to throw away unsaved changes unless force is set
+func Quit(s *State, force bool) error {
+	if CloseView(s) {
+		return nil // the buffer stays open, only the split is gone
+	}
+	if !force && s.buf.Modified() {
+		return errors.New("No write since last change (add ! to override)")
+	}
+	if d := ModifiedDocument(s); !force && d != nil {
+		return fmt.Errorf("No write since last change for buffer %d (add ! to override)", d.id)
+	}
+	s.quit = true
+	return nil
+}
+
+// SaveAndQuit writes the buffer and quits only if the write succeeded
+func SaveAndQuit(s *State) error {
+	if err := Save(s); err != nil {
+		return err
+	}
+	return Quit(s, false)
+}
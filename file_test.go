@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenFile(t *testing.T) {
+	long := strings.Repeat("a line longer than one screen\n", 500)
+	tests := []struct {
+		name  string
+		data  string
+		lines int
+		noeol bool
+	}{
+		{"empty", "", 1, false},
+		{"no trailing newline", "one\ntwo", 2, true},
+		{"trailing newline", "one\ntwo\n", 2, false},
+		{"longer than a screen", long, 500, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file.txt")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			buf, f, err := OpenFile(path, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.ReadAll(); got != tt.data {
+				t.Errorf("OpenFile() read %d runes, want %d", len(got), len(tt.data))
+			}
+			if got := buf.LineCount(); got != tt.lines {
+				t.Errorf("LineCount() = %d, want %d", got, tt.lines)
+			}
+			if f.noeol != tt.noeol {
+				t.Errorf("noeol = %v, want %v", f.noeol, tt.noeol)
+			}
+		})
+	}
+}
+
+func TestReadTextMissing(t *testing.T) {
+	text, f, err := ReadText(filepath.Join(t.TempDir(), "new.txt"), "")
+	if err != nil || text != "" {
+		t.Errorf("ReadText() = %q, %v, want an empty buffer for a new file", text, err)
+	}
+	if f != defaultFormat {
+		t.Errorf("format = %+v, want the default", f)
+	}
+}
+
+func TestUndoFileWithoutFinalNewline(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	open := func() *State {
+		s := newTestState(t, "")
+		s.undofile, s.fixeol = true, true
+		if err := OpenDocument(s, path, ""); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	s := open()
+	s.buf.SetCursor(3)
+	s.buf.Write(" two")
+	if err := Write(s, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "one two\n" {
+		t.Fatalf("wrote %q, want the final newline added", data)
+	}
+	s = open()
+	if !s.buf.Undo() {
+		t.Fatal("no change to undo after reading the file back")
+	}
+	if got := s.buf.ReadAll(); got != "one\n" {
+		t.Errorf("undo gave %q, want %q", got, "one\n")
+	}
+}
+
+func TestWriteRefusedByBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("one  \ntwo\t\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestState(t, "")
+	if err := OpenDocument(s, path, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.trimws, s.fixeol = true, true
+	// a backup directory that does not exist refuses the write
+	s.backup, s.backupdir = true, filepath.Join(dir, "missing")
+	if err := Write(s, false); err == nil {
+		t.Fatal("Write() made no backup but did not refuse the write")
+	}
+	if got := s.buf.ReadAll(); got != "one  \ntwo\t\n" || s.buf.Modified() {
+		t.Errorf("a refused write left %q, modified %v, want the buffer untouched", got, s.buf.Modified())
+	}
+	if data, _ := os.ReadFile(path); string(data) != "one  \ntwo\t\n" {
+		t.Errorf("a refused write left %q on disk, want the file untouched", data)
+	}
+}
+
+func TestWriteTrimsOnceWritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("one  \ntwo\t\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestState(t, "")
+	if err := OpenDocument(s, path, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.trimws, s.fixeol = true, true
+	// the file is written to a path that is a directory
+	s.filename = t.TempDir()
+	if err := Write(s, true); err == nil {
+		t.Fatal("Write() into a directory did not fail")
+	}
+	if got := s.buf.ReadAll(); got != "one  \ntwo\t\n" || s.buf.Modified() {
+		t.Errorf("a failed write left %q, modified %v, want the buffer untouched", got, s.buf.Modified())
+	}
+	s.filename = path
+	if err := Write(s, true); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "one\ntwo\n" {
+		t.Errorf("wrote %q, want the lines trimmed", data)
+	}
+	if got := s.buf.ReadAll(); got != "one\ntwo\n" || s.buf.Modified() {
+		t.Errorf("after the write the buffer is %q, modified %v, want it trimmed and saved", got, s.buf.Modified())
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format is how the text of a file is stored on disk, as opposed to the
+// buffer which always has \n line endings
+type Format struct {
+	// line ending: unix for \n, dos for \r\n or mac for \r, :set fileformat
+	fileformat string
+	mixed      bool // lines ended in more than one way when read
+	noeol      bool // the file does not end with a newline
+	// whether fixendofline may add the missing newline, :set endofline
+	endofline bool
+	bomb      bool // the file starts with a byte order mark, :set bomb
+	// what the bytes of the file mean, one of encodings, :set fileencoding
+	encoding string
+	invalid  bool // bytes that are not UTF-8 were replaced when read
+	binary   bool // not text, read byte for byte
+}
+
+// byte order mark some editors put at the start of UTF-8 files
+const bom = "\ufeff"
+
+// the format of new files
+var defaultFormat = Format{fileformat: "unix", endofline: true, encoding: "utf-8"}
+
+// how much of a file IsBinary looks at
+const binaryCheckSize = 8 << 10
+
+// IsBinary reports whether data is not text, which it takes a NUL byte near
+// the start to mean
+func IsBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), binaryCheckSize)], 0) >= 0
+}
+
+// fileformats are the values fileformat takes
+var fileformats = []string{"unix", "dos", "mac"}
+
+// DecodeText turns data read from a file in encoding enc into buffer text.
+// Without an encoding it is UTF-8 unless the data is not valid UTF-8 and
+// GuessEncoding finds one that fits, or binary when IsBinary says so. A
+// byte order mark is taken off and bytes that are not UTF-8 become U+FFFD,
+// so the buffer only ever holds valid text. The line ending most lines use
+// decides the format, unix on a tie, and those lines are converted to \n.
+// Lines ending another way are noted as mixed, and with a unix format any
+// \r before a \n stays in the text.
+func DecodeText(data []byte, enc string) (string, Format) {
+	text := string(data)
+	f := defaultFormat
+	if enc == "" && IsBinary(data) {
+		// a byte per rune and nothing converted, so writing it back
+		// gives the same bytes
+		f.binary, f.encoding, f.endofline = true, "latin1", false
+		return DecodeBytes(text, f.encoding), f
+	}
+	if enc == "" && !utf8.ValidString(text) {
+		enc = GuessEncoding(text)
+	}
+	if enc != "" {
+		f.encoding = enc
+	}
+	if f.encoding != "utf-8" {
+		text = DecodeBytes(text, f.encoding)
+	} else if strings.HasPrefix(text, bom) {
+		text, f.bomb = text[len(bom):], true
+	}
+	if !utf8.ValidString(text) {
+		text, f.invalid = strings.ToValidUTF8(text, "\ufffd"), true
+	}
+	crlf := strings.Count(text, "\r\n")
+	lf := strings.Count(text, "\n") - crlf
+	cr := strings.Count(text, "\r") - crlf
+	switch {
+	case crlf > lf && crlf >= cr:
+		f.fileformat = "dos"
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	case cr > lf && cr > crlf:
+		f.fileformat = "mac"
+		text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+	}
+	kinds := 0
+	for _, n := range []int{crlf, lf, cr} {
+		if n > 0 {
+			kinds++
+		}
+	}
+	f.mixed = kinds > 1
+	f.noeol = text != "" && !strings.HasSuffix(text, "\n")
+	return text, f
+}
+
+// EncodeText turns buffer text back into what is written to a file in
+// format f, counting the characters its encoding has no bytes for
+func EncodeText(text string, f Format) (string, int) {
+	switch f.fileformat {
+	case "dos":
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	case "mac":
+		text = strings.ReplaceAll(text, "\n", "\r")
+	}
+	if f.bomb && f.encoding == "utf-8" {
+		text = bom + text
+	}
+	return EncodeBytes(text, f.encoding)
+}
+
+// Size is the number of bytes text takes in a file in format f
+func (f Format) Size(text string) int {
+	data, _ := EncodeText(text, f)
+	return len(data)
+}
+
+// FixEndOfLine adds the newline missing from the end of text when fix is
+// set and f allows it
+func FixEndOfLine(text string, f Format, fix bool) string {
+	if fix && f.endofline && text != "" && !strings.HasSuffix(text, "\n") {
+		return text + "\n"
+	}
+	return text
+}
+
+// Note describes f for the message shown when a file is read, nothing for
+// a plain unix file
+func (f Format) Note() string {
+	var note string
+	if f.binary {
+		return " [binary]"
+	}
+	if f.encoding != "utf-8" {
+		note += " [" + f.encoding + "]"
+	}
+	if f.fileformat != "unix" {
+		note += " [" + f.fileformat + "]"
+	}
+	if f.mixed {
+		note += " [mixed line endings]"
+	}
+	if f.noeol {
+		note += " [noeol]"
+	}
+	return note
+}
+
+// Warning is what went wrong reading a file in format f, if anything
+func (f Format) Warning() error {
+	if f.binary {
+		return errors.New("File is binary, set noreadonly to edit it")
+	}
+	if f.invalid {
+		return errors.New("File contains invalid UTF-8")
+	}
+	return nil
+}
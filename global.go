@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Global is :g/pattern/cmd, running the ex command cmd on each of the lines
+// from to to that match pattern, or with invert on those that do not like
+// :v. The lines are marked first and then visited, so a command that
+// deletes lines does not throw off the ones still to come, and a marked
+// line deleted along the way is skipped. Without cmd the lines are listed.
+func Global(s *State, from int, to int, args string, invert bool) error {
+	if s.global {
+		return errors.New("Cannot do :global recursive")
+	}
+	if args == "" {
+		return errors.New("Regular expression missing from :global")
+	}
+	parts := append(splitDelimited(args, 2), "")
+	pattern, cmd := parts[0], parts[1]
+	if pattern == "" {
+		pattern = s.lastSearch
+	}
+	re, err := CompilePattern(s, pattern)
+	if err != nil {
+		return err
+	}
+	var lines, marks []int
+	for y := from; y <= to; y++ {
+		start, end := LineExtent(s.buf, s.buf.LineStart(y))
+		if re.MatchString(s.buf.Slice(start, end)) != invert {
+			lines = append(lines, y)
+			marks = append(marks, start)
+		}
+	}
+	if len(marks) == 0 {
+		return errors.New("Pattern not found: " + pattern)
+	}
+	if strings.TrimSpace(cmd) == "" {
+		return listLines(s, lines)
+	}
+	s.buf.MarkLines(marks)
+	s.global = true
+	defer func() {
+		s.buf.MarkLines(nil)
+		s.global = false
+	}()
+	// messages of the command on each line would bury each other, only the
+	// total is shown
+	messages := s.messages
+	lineCount := s.buf.LineCount()
+	changed := 0
+	var failed error
+	for i := range marks {
+		offset, ok := s.buf.LineMark(i)
+		if !ok {
+			continue
+		}
+		MoveToOffset(s, offset)
+		version := s.buf.Version()
+		if err := RunCommand(s, cmd); err != nil && failed == nil {
+			failed = err
+		}
+		if s.buf.Version() != version {
+			changed++
+		}
+	}
+	s.messages, s.message = messages, Message{}
+	switch {
+	case changed == 0 && failed != nil:
+		return failed
+	case s.buf.LineCount() < lineCount:
+		Info(s, fmt.Sprintf("%d fewer lines", lineCount-s.buf.LineCount()))
+	default:
+		Info(s, fmt.Sprintf("%d lines changed", changed))
+	}
+	return nil
+}
+
+// listLines shows lines in the pager with their numbers
+func listLines(s *State, lines []int) error {
+	s.pager = nil
+	for _, y := range lines {
+		start, end := LineExtent(s.buf, s.buf.LineStart(y))
+		s.pager = append(s.pager, Message{text: fmt.Sprintf("%4d %s", y+1, s.buf.Slice(start, end))})
+	}
+	s.pagerTop = 0
+	s.status = PAGER
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestGlobalEscapedDelimiter(t *testing.T) {
+	tests := []struct {
+		args   string
+		invert bool
+		want   string
+	}{
+		{`/a\/b/d`, false, "ab\nb/a\n"},
+		{`/a\/b/s/b/x/`, false, "a/x\nab\nb/a\na/x\n"},
+		{`/a\/b/d`, true, "a/b\na/b\n"},
+		{`#/#d`, false, "ab\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			s := newTestState(t, "a/b\nab\nb/a\na/b\n")
+			if err := Global(s, 0, s.buf.LineCount()-1, tt.args, tt.invert); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.buf.ReadAll(); got != tt.want {
+				t.Errorf(":g%s gave %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
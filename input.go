@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gbin/goncurses"
+)
+
+// how often ReadKey checks for a resize while waiting for a key
+const pollInterval = 50 * time.Millisecond
+
+// default time to wait for the rest of an escape sequence, vim's ttimeoutlen
+const defaultTTimeout = 100 * time.Millisecond
+
+// escape sequences curses did not translate itself, because their bytes
+// arrived too far apart, mapped to the keys they stand for
+var escapeSequences = map[string]goncurses.Key{
+	"[A": goncurses.KEY_UP, "OA": goncurses.KEY_UP,
+	"[B": goncurses.KEY_DOWN, "OB": goncurses.KEY_DOWN,
+	"[C": goncurses.KEY_RIGHT, "OC": goncurses.KEY_RIGHT,
+	"[D": goncurses.KEY_LEFT, "OD": goncurses.KEY_LEFT,
+	"[H": goncurses.KEY_HOME, "OH": goncurses.KEY_HOME, "[1~": goncurses.KEY_HOME,
+	"[F": goncurses.KEY_END, "OF": goncurses.KEY_END, "[4~": goncurses.KEY_END,
+	"[2~": goncurses.KEY_IC,
+	"[3~": goncurses.KEY_DC,
+	"[5~": goncurses.KEY_PAGEUP,
+	"[6~": goncurses.KEY_PAGEDOWN,
+}
+
+// ReadKey waits for the next key. A signal on resized makes it return
+// KEY_RESIZE after curses has picked up the new terminal size, and if wait
+// is positive it gives up and returns 0 after that long. The Go runtime
+// owns SIGWINCH, so curses never sees it on its own.
+func ReadKey(s *State, resized <-chan os.Signal, wait time.Duration) goncurses.Key {
+	if len(s.input) > 0 {
+		key := s.input[0]
+		s.input = s.input[1:]
+		return key
+	}
+	w := s.window
+	w.Timeout(int(pollInterval / time.Millisecond))
+	start := time.Now()
+	for {
+		select {
+		case <-resized:
+			goncurses.End()
+			w.Refresh()
+			return goncurses.KEY_RESIZE
+		default:
+		}
+		if key := w.GetChar(); key == 27 {
+			return readEscape(s)
+		} else if key != 0 {
+			return key
+		}
+		if wait > 0 && time.Since(start) >= wait {
+			return 0
+		}
+	}
+}
+
+// readEscape decides what an escape byte was. Only when nothing follows
+// within ttimeout is it a bare Escape, otherwise the bytes after it are
+// read as an escape sequence. Bytes that do not make up one are queued to
+// be read as keys of their own after the Escape.
+func readEscape(s *State) goncurses.Key {
+	s.window.Timeout(max(int(s.ttimeout/time.Millisecond), 1))
+	var seq []goncurses.Key
+	for {
+		key := s.window.GetChar()
+		if key == 0 {
+			break
+		}
+		seq = append(seq, key)
+		if len(seq) == 1 && key != '[' && key != 'O' {
+			break
+		}
+		if len(seq) > 1 && (key == '~' || key >= 'A' && key <= 'Z' || key >= 'a' && key <= 'z') {
+			break
+		}
+	}
+	var text []rune
+	for _, key := range seq {
+		text = append(text, rune(key))
+	}
+	if key, ok := escapeSequences[string(text)]; ok {
+		return key
+	}
+	s.input = append(s.input, seq...)
+	return 27
+}
+
+// AssembleRune turns s.key into the character typed, collecting the bytes of
+// a multi-byte UTF-8 character across keys. It reports false for keys that
+// are not characters and while a character is still incomplete, which
+// Partial tells apart.
+func AssembleRune(s *State) (rune, bool) {
+	if s.key < 0 || s.key > 0xff {
+		s.partial = nil
+		return 0, false
+	}
+	if s.key < utf8.RuneSelf {
+		s.partial = nil
+		return rune(s.key), true
+	}
+	s.partial = append(s.partial, byte(s.key))
+	if !utf8.FullRune(s.partial) {
+		return 0, false
+	}
+	r, size := utf8.DecodeRune(s.partial)
+	s.partial = nil
+	return r, r != utf8.RuneError || size > 1
+}
+
+// Partial reports whether AssembleRune is in the middle of a character
+func Partial(s *State) bool {
+	return len(s.partial) > 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"unicode"
+
+	"github.com/gbin/goncurses"
+)
+
+// StartRecording begins recording keys into register name, an uppercase
+// name appends to the existing macro
+func StartRecording(s *State, name rune) error {
+	if name > unicode.MaxASCII || !unicode.IsLetter(name) {
+		return nil
+	}
+	s.recording = name
+	s.macro = nil
+	if unicode.IsUpper(name) {
+		for _, r := range GetNamedRegister(s, name).text {
+			s.macro = append(s.macro, goncurses.Key(r))
+		}
+	}
+	return nil
+}
+
+// StopRecording stores the recorded keys, without the final q, in the
+// macro's register
+func StopRecording(s *State) {
+	keys := s.macro[:max(len(s.macro)-1, 0)]
+	runes := make([]rune, len(keys))
+	for i, k := range keys {
+		runes[i] = rune(k)
+	}
+	if s.registers == nil {
+		s.registers = make(map[rune]Register)
+	}
+	s.registers[unicode.ToLower(s.recording)] = Register{text: string(runes)}
+	s.recording = 0
+	s.macro = nil
+}
+
+// PlayMacro feeds register name through the key handlers n times, @@
+// replays the last macro. Playback stops early when a motion fails.
+func PlayMacro(s *State, name rune, n int) error {
+	if name == '@' {
+		name = s.lastMacro
+	}
+	if name == 0 {
+		return errors.New("No previously used register")
+	}
+	s.lastMacro = name
+	keys := GetNamedRegister(s, name).text
+	s.count, s.regName = 0, 0
+	s.playing++
+	key := s.key
+	defer func() { s.playing--; s.key = key }()
+	for i := 0; i < n; i++ {
+		for _, r := range keys {
+			s.key = goncurses.Key(r)
+			s.failed = false
+			if err := HandleKey(s); err != nil {
+				return err
+			}
+			if s.failed {
+				return nil
+			}
+		}
+	}
+	return nil
+}
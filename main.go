@@ -1,10 +1,16 @@
 package main
 
 import (
-	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"regexp"
 	"strings"
+	"syscall"
+	"time"
+	"unicode"
 
 	"github.com/gbin/goncurses"
 )
@@ -13,217 +19,922 @@ const (
 	NORMAL = iota
 	INSERT
 	VISUAL
+	VISUAL_LINE
+	REPLACE
+	SEARCH
+	COMMAND
+	PAGER
+	CONFIRM
 )
 
 type State struct {
-	key    goncurses.Key
-	buf    TextBuffer
-	status int
-	window *goncurses.Window
-	y      int
-	x      int
+	*Document // the buffer being edited
+	key       goncurses.Key
+	status    int
+	window    *goncurses.Window
+	y         int
+	x         int
+	anchor    int       // buffer offset where the visual selection started
+	message   Message   // shown on the message line until the next key
+	messages  []Message // history for :messages
+	pending   string    // keys of an unfinished multi-key command
+	count     int       // count typed before a command, 0 when there is none
+	lastFind  Find
+	regName   rune // register chosen with a " prefix, 0 for the unnamed one
+	registers map[rune]Register
+	replaced  []rune // runes overwritten in REPLACE mode, 0 where text was appended
+	// keys of the command being typed, the last change for . and whether
+	// the current command is still changing text in INSERT or REPLACE
+	change     []goncurses.Key
+	lastChange []goncurses.Key
+	changing   bool
+	replaying  bool
+	version    int // buffer version when the current command started
+	// macro register being recorded into, the keys recorded so far, the
+	// last register played with @ and whether a motion in it failed
+	recording rune
+	macro     []goncurses.Key
+	lastMacro rune
+	playing   int
+	failed    bool
+	// text typed at the / or ? prompt and the last search
+	prompt        string
+	promptPrefix  string
+	lastSearch    string
+	searchRE      *regexp.Regexp // lastSearch as last compiled, kept for redraws
+	searchForward bool
+	incFrom       int // match of the incremental search while typing
+	incTo         int
+	searchTop     int // topLine and leftCol when the search prompt opened
+	searchLeft    int
+	hlsearch      bool // highlight every match of the last search, :set hlsearch
+	nohlsearch    bool // matches hidden by :noh until the next search
+	quit          bool
+	number        bool // show line numbers in a gutter, :set number
+	relative      bool // number lines relative to the cursor, :set relativenumber
+	topLine       int  // first buffer line shown in the window
+	scrolloff     int  // lines kept visible above and below the cursor, :set scrolloff
+	height        int  // number of rows of text the window shows
+	leftCol       int  // first screen column shown when lines are not wrapped
+	wrap          bool // soft wrap long lines, :set wrap
+	debug         bool // show the last key code and redraw count, :set debug
+	// lines shown by the pager, the first one on screen and the page size
+	pager       []Message
+	pagerTop    int
+	pagerHeight int
+	// keys read ahead while parsing an escape sequence and how long to wait
+	// for the rest of one, :set ttimeoutlen
+	input    []goncurses.Key
+	ttimeout time.Duration
+	// mappings by mode letter, keys left to handle after expanding one,
+	// expansions since the last typed key and how long to wait for the rest
+	// of a mapping, :set timeoutlen
+	mappings   map[byte]*keyTrie
+	typeahead  []typedKey
+	mapDepth   int
+	timeoutlen time.Duration
+	partial    []byte // bytes of a UTF-8 character still being typed
+	jump       int    // position in the jump list while walking it, past its end otherwise
+	tabstop    int    // columns between tab stops, :set tabstop
+	expandtab  bool   // Tab inserts spaces instead of a tab, :set expandtab
+	// new lines copy the indent of the one before and how wide an indent
+	// level is, :set autoindent and shiftwidth
+	autoindent bool
+	shiftwidth int
+	// comment format for file types gc does not know, :set commentstring
+	commentstring string
+	undofile      bool        // keep undo history across sessions, :set undofile
+	pagerExit     int         // mode to go back to when the pager closes
+	documents     []*Document // every open buffer, in the order opened
+	layout        *Layout     // the split windows
+	// --stdout prints output, the text last written from the first buffer,
+	// on exit
+	stdout bool
+	output string
+	config string  // the config file read at startup, for :source
+	focus  *Layout // leaf of the view being edited
+	lastID int     // number given to the newest buffer
+	// question asked on the message line and what to do with the key that
+	// answers it
+	question string
+	answer   func(*State) error
+	// write the buffer after this long without a key, :set autosave and
+	// autosavetime, and when the last key came and the file was written
+	autosave     bool
+	autosavetime time.Duration
+	lastKey      time.Time
+	written      time.Time
+	// keep the old contents as file~ on write, or in backupdir, :set backup
+	backup    bool
+	backupdir string
+	fixeol    bool // add a missing newline at the end on write, :set fixendofline
+	// show tabs and trailing spaces with the glyphs of listchars, :set list
+	list      bool
+	listchars string
+	trimws    bool // strip trailing whitespace on write, :set trimtrailingws
+	// underline the line the cursor is on, :set cursorline
+	cursorline bool
+	colors     bool   // the terminal has colors and they are in use
+	theme      string // palette the colors come from, :set theme
+	// searches ignore case, unless the pattern has uppercase letters with
+	// smartcase, :set ignorecase and smartcase
+	ignorecase bool
+	smartcase  bool
+	global     bool // a :g is running its command over the marked lines
 }
 
 const pad = 2
 
-// gap buffer
-type TextBuffer interface {
-	ReadAll() string
-	Write(string) error
-	WriteChar(rune) error
-	Delete() error
-	ChangeCursorPosition(int, int) error
-	LineLength() int
-	Select(int, int) error
-}
-
-type TextGapBuffer struct {
-	left  strings.Builder
-	right strings.Builder
-}
-
-func NewTextGapBuffer(text string) (*TextGapBuffer, error) {
-	tgb := &TextGapBuffer{}
-	_, err := tgb.left.WriteString(text)
-	return tgb, err
-}
-
-func (tgb *TextGapBuffer) ReadAll() string {
-	return tgb.left.String() + tgb.right.String()
-}
-
-func (tgb *TextGapBuffer) Write(text string) error {
-	_, err := tgb.left.WriteString(text)
-	return err
-}
-
-func (tgb *TextGapBuffer) WriteChar(char rune) error {
-	_, err := tgb.left.WriteString(string(char))
-	return err
-}
-
-func (tgb *TextGapBuffer) Delete() error {
-	s := tgb.left.String()
-	tgb.left.Reset()
-	if len(s) > 0 {
-		_, err := tgb.left.WriteString(s[:len(s)-1])
-		return err
+// n is relative movement
+func MoveX(s *State, n int) {
+	x := Column(s)
+	s.x = max(min(x+n, LastColumn(s, s.buf.LineLength())), 0)
+	if err := s.buf.ChangeCursorPosition(s.y, s.x); err != nil {
+		s.x = x
 	}
-	return nil
+	s.failed = s.x == x && n != 0
 }
 
-func (tgb *TextGapBuffer) ChangeCursorPosition(y int, x int) error {
-	return errors.New("not implemented")
+// n is relative movement, the cursor keeps its screen column rather than
+// its rune column so it lines up across tabs
+func MoveY(s *State, n int) {
+	y, x := s.y, s.x
+	col := s.buf.CursorColumn(s.tabstop) + s.x - Column(s)
+	s.y = max(min(y+n, s.buf.LineCount()-1), 0)
+	if s.y != y {
+		text := LineText(s.buf, s.y)
+		s.x = ColumnRune(text, col, s.tabstop)
+		if s.x == len(text) {
+			s.x += col - DisplayWidth(text, s.tabstop) // remember how far past the end
+		}
+	}
+	if err := s.buf.ChangeCursorPosition(s.y, 0); err != nil {
+		s.y, s.x = y, x // past the last line
+	}
+	s.buf.ChangeCursorPosition(s.y, Column(s))
+	s.failed = s.y == y && n != 0
 }
 
-func (tgb *TextGapBuffer) LineLength() int {
-	s := tgb.left.String()
-	i := strings.LastIndex(s, "\n")
-	if i == -1 { // on first line
-		return len(s)
-	} else {
-		return len(s) - i
+// LastColumn is the furthest rune column the cursor can rest on in a line
+// of ll runes, past the end in INSERT and REPLACE mode and on the last
+// character otherwise
+func LastColumn(s *State, ll int) int {
+	if s.status == INSERT || s.status == REPLACE {
+		return ll
 	}
+	return max(ll-1, 0)
 }
 
-func (tgb *TextGapBuffer) Select(from int, to int) error {
-	return errors.New("not implemented")
+// Column is the rune column the cursor is really on. s.x can be further
+// right, after a vertical move onto a shorter line, so moving on to a
+// longer line returns to the column the move started from.
+func Column(s *State) int {
+	return min(s.x, LastColumn(s, s.buf.LineLength()))
 }
 
-// n is relative movement
-func MoveX(s *State, n int) {
-	_, x := s.window.CursorYX()
-	_, maxX := s.window.MaxYX()
-	ll := s.buf.LineLength()
-	s.x = x + n
-
-	if s.x < 0 {
-		s.x = 0
-	} else if s.x >= maxX {
-		s.x = maxX - 1
-	} else if s.x >= ll {
-		s.x = ll
+// PrintStatus draws the status bar in reverse video on the bottom row: the
+// mode, file name and flags on the left and note and the cursor position on
+// the right. A file name too long for the window is cut from the left.
+func PrintStatus(w *goncurses.Window, s *State, note string) {
+	maxY, maxX := w.MaxYX()
+	name := s.filename
+	if name == "" {
+		name = "[No Name]"
 	}
-}
-
-// n is relative movement
-func MoveY(s *State, n int) {
-	y, _ := s.window.CursorYX()
-	maxY, _ := s.window.MaxYX()
-	s.y = y + n
-
-	if s.y < 0 {
-		s.y = 0
-	} else if s.y >= maxY-1 {
-		s.y = maxY - 2
+	var flags string
+	if s.buf.Modified() {
+		flags += " [+]"
 	}
-}
-
-func PrintError(w *goncurses.Window, e error) {
-	if e == nil {
-		return
+	if s.buf.ReadOnly() {
+		flags += " [RO]"
+	}
+	if s.format.binary {
+		flags += " [binary]"
 	}
-	maxY, _ := w.MaxYX()
-	w.MovePrint(maxY-1, pad, e)
+	if s.format.noeol {
+		flags += " [noeol]"
+	}
+	if s.recording != 0 {
+		flags += " recording @" + string(s.recording)
+	}
+	right := fmt.Sprintf("%s[%s]  %d,%d  %d%%", s.format.encoding, s.format.fileformat, s.y+1, Column(s)+1, (s.y+1)*100/s.buf.LineCount())
+	if !s.written.IsZero() {
+		right = "written " + s.written.Format("15:04:05") + "  " + right
+	}
+	if note != "" {
+		right = note + "  " + right
+	}
+	mode := "[" + ModeName(s.status) + "] "
+	room := maxX - 2*pad - len(mode) - len(flags) - len(right) - 1
+	if room < 1 {
+		right, room = "", room+len(right)
+	}
+	if r := []rune(name); len(r) > room {
+		name = "<" + string(r[len(r)-max(room-1, 0):])
+	}
+	left := strings.Repeat(" ", pad) + mode + name + flags
+	bar := []rune(left + strings.Repeat(" ", max(maxX-len([]rune(left))-len(right)-pad, 1)) + right)
+	bar = append(bar, []rune(strings.Repeat(" ", pad))...)
+	w.AttrSet(Attr(s, StatusStyle))
+	w.MovePrint(maxY-1, 0, string(bar[:min(len(bar), maxX)]))
+	w.AttrSet(goncurses.A_NORMAL)
 }
 
-func PrintStatus(w *goncurses.Window, status int) {
-	var msg string
+// ModeName is the name shown in the status bar for a mode
+func ModeName(status int) string {
 	switch status {
-	case NORMAL:
-		msg = "[NORMAL]"
 	case INSERT:
-		msg = "[INSERT]"
+		return "INSERT"
 	case VISUAL:
-		msg = "[VISUAL]"
+		return "VISUAL"
+	case VISUAL_LINE:
+		return "VISUAL LINE"
+	case REPLACE:
+		return "REPLACE"
 	}
-	maxY, _ := w.MaxYX()
-	w.MovePrint(maxY-1, pad, msg)
+	return "NORMAL"
 }
 
-func PrintInfo(w *goncurses.Window, args ...interface{}) {
-	maxY, maxX := w.MaxYX()
-	s := fmt.Sprint(args...)
-	w.MovePrint(maxY-1, maxX-len(s)-pad, s)
+// PrintPrompt draws a command line prompt on the message line and leaves the
+// cursor after the typed text
+func PrintPrompt(w *goncurses.Window, s *State, prefix string, text string) {
+	PrintMessage(w, s, Message{text: prefix + text})
 }
 
 func main() {
-	src, err := goncurses.Init()
-	if err != nil {
-		log.Fatal("Error initializing curses. ", err)
+	if os.Getenv("ESCDELAY") == "" {
+		// curses only has to catch sequences that arrive in one read,
+		// ReadKey waits ttimeout for slower ones itself
+		os.Setenv("ESCDELAY", "10")
+	}
+	readonly := flag.Bool("R", false, "read-only mode, edits are refused")
+	stdout := flag.Bool("stdout", false, "print the written text on exit, for pipelines")
+	config := flag.String("u", ConfigPath(), "config file to read, NONE for none")
+	batch := flag.Bool("batch", false, "run the -c commands without a screen and exit")
+	var commands commandList
+	flag.Var(&commands, "c", "ex command to run in -batch mode, may be repeated")
+	flag.Parse()
+	filename := flag.Arg(0)
+	var buf *TextGapBuffer
+	var format Format
+	var openerr, err error
+	if filename == "-" {
+		filename = ""
+		if buf, format, err = ReadStdin(); err != nil {
+			log.Fatal("Error reading stdin. ", err)
+		}
+	} else if buf, format, openerr = OpenFile(filename, ""); openerr != nil {
+		filename = ""
+		buf, err = NewTextGapBuffer("")
 	}
-	defer goncurses.End()
-	goncurses.Echo(false)
-
-	buf, err := NewTextGapBuffer("Focus on the donut, not the hole")
 	if err != nil {
 		log.Fatal("Error initializing gap buffer. ", err)
 	}
 
 	var state = &State{
-		key:    0,
-		buf:    buf,
-		status: NORMAL,
-		window: src,
+		key:           0,
+		status:        NORMAL,
+		ttimeout:      defaultTTimeout,
+		timeoutlen:    defaultTimeout,
+		tabstop:       defaultTabstop,
+		shiftwidth:    defaultShiftwidth,
+		commentstring: defaultCommentString,
+		undofile:      true,
+		fixeol:        true,
+		hlsearch:      true,
+		listchars:     defaultListChars,
+		theme:         defaultTheme,
+		autosavetime:  defaultAutoSaveTime,
+		lastKey:       time.Now(),
+		stdout:        *stdout,
+	}
+	state.Document = NewDocument(state, buf, filename)
+	state.format = format
+	state.output, _ = EncodeText(buf.ReadAll(), format)
+	if format.mixed {
+		Info(state, fmt.Sprintf("%q%s", filename, format.Note()))
+	}
+	Error(state, format.Warning())
+	buf.SetReadOnly(*readonly || !Writable(filename) || format.binary)
+	state.documents = []*Document{state.Document}
+	state.layout = &Layout{view: &View{doc: state.Document}}
+	state.focus = state.layout
+	Error(state, openerr)
+	if *config != "NONE" && *config != "" {
+		state.config = *config
+		Error(state, Source(state, state.config))
+	}
+	Error(state, LoadUndo(state))
+	if *batch {
+		RunBatch(state, commands, openerr)
+		return
 	}
-	var keyerr error
+
+	src, err := InitScreen()
+	if err != nil {
+		log.Fatal("Error initializing curses. ", err)
+	}
+	goncurses.Echo(false)
+	goncurses.Raw(true) // deliver ctrl-s and friends instead of flow control
+	src.Keypad(true)    // arrows and friends arrive as single KEY_ codes
+	state.window = src
+	StartColors(state)
+	defer Finish(state)
+	Error(state, CheckSwap(state))
+	defer CloseDocuments(state)
+	Error(state, buf.ChangeCursorPosition(state.y, state.x))
+
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
 
 	calls := 0
-    loop:
-	for {
+	for !state.quit {
 		src.Erase()
 		calls += 1
-		src.Print(buf.ReadAll())
-		PrintInfo(src, state.key, calls)
-		PrintStatus(src, state.status)
-		PrintError(src, keyerr)
-		src.Move(state.y, state.x)
+		if state.status == PAGER {
+			PrintPager(src, state)
+			src.Refresh()
+			if state.key = ReadKey(state, resized, 0); state.key == goncurses.KEY_RESIZE {
+				src.Clear()
+			} else {
+				HandlePager(state)
+			}
+			continue
+		}
+		note := PendingKeys(state)
+		if note == "" && state.debug {
+			note = fmt.Sprint(state.key, " ", calls)
+		}
+		row, col := DrawViews(src, state, note)
+		if state.status == SEARCH || state.status == COMMAND {
+			PrintPrompt(src, state, state.promptPrefix, state.prompt)
+		} else if state.status == CONFIRM {
+			PrintPrompt(src, state, "", state.question)
+		} else {
+			if state.message.text != "" {
+				PrintMessage(src, state, state.message)
+			}
+			src.Move(row, col)
+		}
 		src.Refresh()
 
-		state.key = src.GetChar()
-
-		switch state.status {
-		case NORMAL:
-			keyerr = HandleNormal(state)
-            if state.key == 113 { // q
-                break loop
-            }
-		case INSERT:
-			keyerr = HandleInsert(state)
-		case VISUAL:
+		var wait time.Duration
+		if state.message.text != "" && !state.message.err {
+			wait = infoTimeout
+		}
+		if SwapPending(state) {
+			wait = updateTime
+		}
+		if due, ok := AutoSaveDue(state); ok && (wait == 0 || due < wait) {
+			wait = max(due, time.Millisecond)
+		}
+		state.key = NextKey(state, resized, wait)
+		if state.key == goncurses.KEY_RESIZE {
+			src.Clear() // repaint everything at the new size
+			continue
+		}
+		if state.key == 0 {
+			// idle: an info message timed out or a write is due
+			if !state.message.err {
+				state.message = Message{}
+			}
+			Error(state, AutoSave(state))
+			UpdateSwap(state, true)
+			continue
+		}
+		state.message = Message{}
+		state.lastKey = time.Now()
 
+		Error(state, HandleKey(state))
+		// a question may be part of a command still editing, like :s///c
+		if state.status != INSERT && state.status != REPLACE && state.status != CONFIRM {
+			state.buf.Checkpoint()
 		}
+		UpdateSwap(state, false)
 	}
 }
 
+// HandleKey dispatches s.key to the handler for the current mode
+func HandleKey(s *State) error {
+	if s.replaying {
+		return dispatch(s)
+	}
+	if s.recording != 0 && s.playing == 0 {
+		s.macro = append(s.macro, s.key)
+	}
+	fresh := s.status == NORMAL && s.pending == "" && s.count == 0 && s.regName == 0
+	if fresh && !s.changing {
+		s.change = nil
+		s.version = s.buf.Version()
+	}
+	s.change = append(s.change, s.key)
+	err := dispatch(s)
+	TrackChange(s)
+	return err
+}
+
+func dispatch(s *State) error {
+	switch s.status {
+	case NORMAL:
+		return HandleNormal(s)
+	case INSERT:
+		return HandleInsert(s)
+	case VISUAL, VISUAL_LINE:
+		return HandleVisual(s)
+	case REPLACE:
+		return HandleReplace(s)
+	case SEARCH:
+		return HandleSearch(s)
+	case COMMAND:
+		return HandleCommand(s)
+	case CONFIRM:
+		return HandleConfirm(s)
+	}
+	return nil
+}
+
 func HandleNormal(s *State) error {
+	if s.pending == "\"" {
+		s.pending = ""
+		if !SelectRegister(s) {
+			s.regName, s.count = 0, 0
+		}
+		return nil
+	}
+	if CountKey(s) {
+		return nil
+	}
+	defer func() {
+		if s.pending == "" {
+			s.regName, s.count = 0, 0
+		}
+	}()
+	if HandleMotion(s) {
+		return nil
+	}
+	if s.pending != "" {
+		return HandlePending(s)
+	}
 	var err error
 	switch s.key {
 	case goncurses.KEY_IC, 105:
 		s.status = INSERT
-	case 104: // h
-		MoveX(s, -1)
-	case 106: // j
-		MoveY(s, 1)
-	case 107: // k
-		MoveY(s, -1)
-	case 108: // l
-		MoveX(s, 1)
+	case 97: // a
+		_, x := s.buf.CursorYX()
+		err = InsertAt(s, min(x+1, s.buf.LineLength()))
+	case 65: // A
+		err = InsertAt(s, s.buf.LineLength())
+	case 73: // I
+		err = InsertAt(s, s.buf.Indent())
+	case 82: // R
+		s.status = REPLACE
+		s.replaced = nil
+	case 118: // v
+		s.status = VISUAL
+		s.anchor = s.buf.Cursor()
+		err = UpdateSelection(s)
+	case 86: // V
+		s.status = VISUAL_LINE
+		s.anchor = s.buf.Cursor()
+		err = UpdateSelection(s)
+	case 117: // u
+		if s.buf.ReadOnly() {
+			err = ErrReadOnly
+		} else if s.buf.Undo() {
+			Info(s, "1 change undone")
+			SyncCursor(s)
+		}
+	case 18: // ctrl-r
+		if s.buf.ReadOnly() {
+			err = ErrReadOnly
+		} else if s.buf.Redo() {
+			Info(s, "1 change redone")
+			SyncCursor(s)
+		}
+	case 19: // ctrl-s
+		err = Save(s)
+	case 113: // q
+		if s.recording != 0 {
+			StopRecording(s)
+		} else {
+			s.pending = "q"
+		}
+	case 90, 121, 100, 99, 34, 114, 64, 109, 62, 60, 23: // Z, y, d, c, ", r, @, m, >, <, ctrl-w
+		s.pending = string(rune(s.key))
+	case 120, goncurses.KEY_DC: // x
+		err = DeleteChars(s, Count(s))
+	case 74: // J
+		err = JoinLines(s, Count(s))
+	case 126: // ~
+		err = ToggleChars(s, Count(s))
+	case 1: // ctrl-a
+		err = AddNumber(s, Count(s))
+	case 24: // ctrl-x
+		err = AddNumber(s, -Count(s))
+	case 46: // .
+		err = Repeat(s)
+	case 47: // /
+		StartSearch(s, "/")
+	case 63: // ?
+		StartSearch(s, "?")
+	case 58: // :
+		StartCommand(s)
+	case 110: // n
+		err = SearchNext(s, false)
+	case 78: // N
+		err = SearchNext(s, true)
+	case 42: // *
+		err = SearchWord(s, true)
+	case 35: // #
+		err = SearchWord(s, false)
+	case 111: // o
+		err = OpenLine(s, false)
+	case 79: // O
+		err = OpenLine(s, true)
+	case 112: // p
+		err = Put(s, false, Count(s))
+	case 80: // P
+		err = Put(s, true, Count(s))
 	}
 	return err
 }
 
+// CountKey collects digits typed before a command into s.count, a leading
+// 0 is left alone so it can move to the start of the line
+func CountKey(s *State) bool {
+	if s.pending != "" || s.key < 48 || s.key > 57 || (s.key == 48 && s.count == 0) {
+		return false
+	}
+	s.count = s.count*10 + int(s.key-48)
+	return true
+}
+
+// Count is the count typed before the current command, 1 when there is none
+func Count(s *State) int {
+	return max(s.count, 1)
+}
+
+// HandlePending finishes a multi-key command once its last key arrives
+func HandlePending(s *State) error {
+	keys := s.pending + string(rune(s.key))
+	s.pending = ""
+	switch keys[0] {
+	case 'q':
+		return StartRecording(s, rune(s.key))
+	case 'm':
+		if s.key == 27 { // escape cancels
+			return nil
+		}
+		return SetUserMark(s, rune(s.key))
+	case '@':
+		return PlayMacro(s, rune(s.key), Count(s))
+	case 0x17: // ctrl-w
+		if s.key < 128 {
+			return HandleWindow(s, byte(s.key))
+		}
+		return nil
+	}
+	if keys[0] == 'r' {
+		switch s.key {
+		case 27: // escape cancels
+			return nil
+		case goncurses.KEY_RETURN, goncurses.KEY_ENTER:
+			return ReplaceChars(s, '\n', Count(s))
+		}
+		if r, ok := AssembleRune(s); ok && unicode.IsPrint(r) {
+			return ReplaceChars(s, r, Count(s))
+		} else if Partial(s) {
+			s.pending = "r"
+		}
+		return nil
+	}
+	if strings.Contains("dcy", keys[:1]) {
+		if keys[1] == 's' {
+			return HandleSurround(s, keys)
+		}
+		if len(keys) == 2 && (keys[1] == 'i' || keys[1] == 'a') {
+			s.pending = keys // wait for the object
+			return nil
+		}
+		if len(keys) == 3 {
+			if from, to, ok := SelectObject(s, rune(s.key), keys[1] == 'a', Count(s)); ok {
+				return Operate(s, keys[0], from, to)
+			}
+			s.failed = true
+			return nil
+		}
+	}
+	switch {
+	case keys == "gc":
+		s.pending = keys // wait for c or a motion
+		return nil
+	case keys == "g\x07": // g ctrl-g
+		return ShowStats(s)
+	case keys == "g-", keys == "g+":
+		n := Count(s)
+		if keys == "g-" {
+			n = -n
+		}
+		if s.buf.ReadOnly() {
+			return ErrReadOnly
+		}
+		if s.buf.Travel(n) {
+			Info(s, fmt.Sprintf("Moved to change %d", CurrentChange(s.buf)))
+			SyncCursor(s)
+		}
+		return nil
+	case keys == "gcc":
+		return ToggleComment(s, s.y, s.y+Count(s)-1)
+	case strings.HasPrefix(keys, "gc"):
+		y := s.y
+		if !HandleMotion(s) || s.pending != "" {
+			s.pending = ""
+			return nil
+		}
+		return ToggleComment(s, min(y, s.y), max(y, s.y))
+	}
+	switch keys {
+	case "ZZ":
+		if s.buf.Modified() {
+			return SaveAndQuit(s)
+		}
+		return Quit(s, false)
+	case "ZQ":
+		return Quit(s, true)
+	case "yy":
+		YankLines(s, Count(s))
+	case "dd":
+		return DeleteLine(s, Count(s))
+	case ">>":
+		return ShiftLines(s, s.y, s.y+Count(s)-1, 1)
+	case "<<":
+		return ShiftLines(s, s.y, s.y+Count(s)-1, -1)
+	}
+	return nil
+}
+
+// PendingKeys shows the register and keys typed so far for a command that
+// has not finished yet
+func PendingKeys(s *State) string {
+	var keys string
+	if s.regName != 0 {
+		keys = "\"" + string(s.regName)
+	}
+	if s.count > 0 {
+		keys += fmt.Sprint(s.count)
+	}
+	for _, r := range s.pending {
+		if r < 32 {
+			keys += "^" + string(r+64) // control keys such as ctrl-w
+		} else {
+			keys += string(r)
+		}
+	}
+	return keys
+}
+
+// SyncCursor moves the screen cursor to wherever the buffer cursor ended up
+func SyncCursor(s *State) {
+	s.y, s.x = s.buf.CursorYX()
+}
+
 func HandleInsert(s *State) error {
 	var err error
 	switch s.key {
 	case 27: // escape
+		// back onto the last inserted character, like vim
+		SyncCursor(s)
+		MoveX(s, -1)
+		s.failed = false // not a motion, a macro must keep going at column 0
 		s.status = NORMAL
+		return nil
 	case goncurses.KEY_RETURN, goncurses.KEY_ENTER:
+		err = NewLine(s)
+	case goncurses.KEY_BACKSPACE, 127:
+		err = Backspace(s)
+	case goncurses.KEY_DC:
+		err = s.buf.DeleteForward()
+	case goncurses.KEY_TAB:
+		err = InsertTab(s)
+	default:
+		if InsertMotion(s) {
+			return nil
+		}
+		// keys without a character of their own, like F5, are ignored
+		if r, ok := AssembleRune(s); ok && unicode.IsPrint(r) {
+			err = s.buf.WriteChar(r)
+		}
+	}
+	SyncCursor(s)
+	return err
+}
+
+func HandleReplace(s *State) error {
+	var err error
+	switch s.key {
+	case 27: // escape
+		s.status = NORMAL
+		s.replaced = nil
+	case goncurses.KEY_RETURN, goncurses.KEY_ENTER:
+		s.replaced = append(s.replaced, 0)
 		err = s.buf.WriteChar('\n')
 	case goncurses.KEY_BACKSPACE, 127:
-		err = s.buf.Delete()
+		err = Unreplace(s)
 	default:
-		err = s.buf.Write(goncurses.KeyString(s.key))
+		if r, ok := AssembleRune(s); ok && unicode.IsPrint(r) {
+			err = Overwrite(s, r)
+		}
 	}
+	SyncCursor(s)
 	return err
 }
+
+func HandleVisual(s *State) error {
+	if s.pending == "\"" {
+		s.pending = ""
+		SelectRegister(s)
+		return nil
+	}
+	if CountKey(s) {
+		return nil
+	}
+	defer func() {
+		if s.pending == "" {
+			s.regName, s.count = 0, 0
+		}
+	}()
+	if s.pending == "i" || s.pending == "a" {
+		return SelectionObject(s)
+	}
+	if s.pending == "S" {
+		return SurroundSelection(s)
+	}
+	if s.pending == "g" && s.key == 7 { // g ctrl-g
+		s.pending = ""
+		return ShowStats(s)
+	}
+	if s.pending == "g" && s.key == 99 { // gc
+		s.pending = ""
+		first, last := SelectionLines(s)
+		if err := EndVisual(s); err != nil {
+			return err
+		}
+		return ToggleComment(s, first, last)
+	}
+	if s.pending != "" {
+		if !HandleMotion(s) {
+			s.pending = ""
+		}
+		return UpdateSelection(s)
+	}
+	switch s.key {
+	case 34: // "
+		s.pending = "\""
+		return nil
+	case 105, 97, 83: // i a S
+		s.pending = string(rune(s.key))
+		return nil
+	case 27: // escape
+		return EndVisual(s)
+	case 100: // d
+		return DeleteSelection(s)
+	case 121: // y
+		SetRegister(s, SelectionRegister(s))
+		from, _ := s.buf.Selection()
+		if err := EndVisual(s); err != nil {
+			return err
+		}
+		err := s.buf.SetCursor(from)
+		SyncCursor(s)
+		return err
+	case 99: // c
+		if err := DeleteSelection(s); err != nil {
+			return err
+		}
+		s.status = INSERT
+		return nil
+	case 74: // J
+		from, to := s.buf.Selection()
+		lines := strings.Count(s.buf.Slice(from, max(to-1, from)), "\n") + 1
+		s.buf.SetCursor(from)
+		if err := JoinLines(s, lines); err != nil {
+			return err
+		}
+		return EndVisual(s)
+	case 62, 60: // > <
+		first, last := SelectionLines(s)
+		dir := Count(s)
+		if s.key == 60 {
+			dir = -dir
+		}
+		if err := EndVisual(s); err != nil {
+			return err
+		}
+		return ShiftLines(s, first, last, dir)
+	case 126: // ~
+		from, to := s.buf.Selection()
+		if err := ToggleCase(s, from, to); err != nil {
+			return err
+		}
+		if err := EndVisual(s); err != nil {
+			return err
+		}
+		err := s.buf.SetCursor(from)
+		SyncCursor(s)
+		return err
+	case 58: // :
+		// the command applies to the selected lines
+		if err := EndVisual(s); err != nil {
+			return err
+		}
+		StartCommand(s)
+		s.prompt = "'<,'>"
+		return nil
+	case 118: // v
+		s.status = VISUAL
+	case 86: // V
+		s.status = VISUAL_LINE
+	default:
+		if !HandleMotion(s) {
+			s.pending = ""
+		}
+	}
+	return UpdateSelection(s)
+}
+
+// SelectionLines is the first and last line the selection touches
+func SelectionLines(s *State) (int, int) {
+	from, to := s.buf.Selection()
+	first := strings.Count(s.buf.Slice(0, from), "\n")
+	return first, first + strings.Count(s.buf.Slice(from, max(to-1, from)), "\n")
+}
+
+// EndVisual clears the selection and goes back to NORMAL mode, leaving the
+// marks '< and '> on its first and last characters
+func EndVisual(s *State) error {
+	from, to := s.buf.Selection()
+	s.buf.SetMark('<', from)
+	s.buf.SetMark('>', max(to-1, from))
+	s.status = NORMAL
+	c := s.buf.Cursor()
+	return s.buf.Select(c, c)
+}
+
+// SelectionRegister copies the selection into a register, line-wise
+// selections always end in a newline
+func SelectionRegister(s *State) Register {
+	r := Register{text: s.buf.SelectedText(), linewise: s.status == VISUAL_LINE}
+	if r.linewise && !strings.HasSuffix(r.text, "\n") {
+		r.text += "\n"
+	}
+	return r
+}
+
+// DeleteSelection removes the selected text into the register
+func DeleteSelection(s *State) error {
+	from, to := s.buf.Selection()
+	var err error
+	if s.status == VISUAL_LINE {
+		err = DeleteLines(s, from, to)
+	} else {
+		SetRegister(s, SelectionRegister(s))
+		err = s.buf.DeleteRange(from, to)
+		SyncCursor(s)
+	}
+	if err != nil {
+		return err
+	}
+	return EndVisual(s)
+}
+
+// SelectionObject selects the text object named by the key after i or a,
+// switching VISUAL LINE mode to VISUAL
+func SelectionObject(s *State) error {
+	around := s.pending == "a"
+	s.pending = ""
+	from, to, ok := SelectObject(s, rune(s.key), around, Count(s))
+	if !ok {
+		s.failed = true
+		return nil
+	}
+	s.status = VISUAL
+	s.anchor = from
+	MoveToOffset(s, to-1)
+	return UpdateSelection(s)
+}
+
+// UpdateSelection selects from the anchor to the cursor, including the
+// character under the cursor, or every line touched in VISUAL LINE mode
+func UpdateSelection(s *State) error {
+	from, to := s.anchor, s.buf.Cursor()
+	if from > to {
+		from, to = to, from
+	}
+	if s.status == VISUAL_LINE {
+		from, _ = s.buf.LineBounds(from)
+		_, to = s.buf.LineBounds(to)
+	} else if to < s.buf.Len() {
+		to++
+	}
+	return s.buf.Select(from, to)
+}
@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+// newTestState is a NORMAL mode state editing text, the cursor at the top
+func newTestState(t *testing.T, text string) *State {
+	t.Helper()
+	buf, err := NewTextGapBuffer(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &State{status: NORMAL, tabstop: defaultTabstop}
+	s.Document = NewDocument(s, buf, "")
+	buf.SetCursor(0)
+	return s
+}
+
+func TestMoveX(t *testing.T) {
+	tests := []struct {
+		name   string
+		x      int
+		n      int
+		want   int
+		failed bool
+	}{
+		{"left of column 0", 0, -1, 0, true},
+		{"right", 1, 2, 3, false},
+		{"past end of line", 2, 10, 4, false},
+		{"at end of line", 4, 1, 4, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t, "hello\nworld")
+			s.buf.ChangeCursorPosition(0, tt.x)
+			s.x = tt.x
+			MoveX(s, tt.n)
+			if s.x != tt.want || s.buf.Cursor() != tt.want {
+				t.Errorf("x = %d, cursor %d, want %d", s.x, s.buf.Cursor(), tt.want)
+			}
+			if s.failed != tt.failed {
+				t.Errorf("failed = %v, want %v", s.failed, tt.failed)
+			}
+		})
+	}
+}
+
+func TestMoveY(t *testing.T) {
+	tests := []struct {
+		name   string
+		y      int
+		n      int
+		want   int
+		failed bool
+	}{
+		{"above the top", 0, -1, 0, true},
+		{"down", 0, 1, 1, false},
+		{"below the bottom", 1, 5, 2, false},
+		{"at the bottom", 2, 1, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t, "one\ntwo\nthree")
+			s.buf.ChangeCursorPosition(tt.y, 0)
+			s.y = tt.y
+			MoveY(s, tt.n)
+			if y, _ := s.buf.CursorYX(); s.y != tt.want || y != tt.want {
+				t.Errorf("y = %d, cursor on %d, want %d", s.y, y, tt.want)
+			}
+			if s.failed != tt.failed {
+				t.Errorf("failed = %v, want %v", s.failed, tt.failed)
+			}
+		})
+	}
+}
+
+func TestMoveYKeepsColumn(t *testing.T) {
+	s := newTestState(t, "abcdef\nab\nabcdef")
+	s.buf.ChangeCursorPosition(0, 4)
+	s.x = 4
+	MoveY(s, 1)
+	if got := Column(s); got != 1 {
+		t.Errorf("on the short line Column() = %d, want 1", got)
+	}
+	MoveY(s, 1)
+	if _, x := s.buf.CursorYX(); x != 4 || Column(s) != 4 {
+		t.Errorf("back on a long line cursor column %d, Column() %d, want 4", x, Column(s))
+	}
+}
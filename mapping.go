@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gbin/goncurses"
+)
+
+// default time to wait for the rest of a mapping, vim's timeoutlen
+const defaultTimeout = time.Second
+
+// expansions allowed before a key comes from the keyboard again, past which
+// the mapping is taken to be recursive
+const maxMapDepth = 1000
+
+// Mapping is the right-hand side of a :map and whether it was made with
+// :noremap, so its keys are not mapped again
+type Mapping struct {
+	rhs     []goncurses.Key
+	noremap bool
+}
+
+// keyTrie holds the mappings of one mode by their left-hand sides, a key
+// per level
+type keyTrie struct {
+	mapping *Mapping
+	next    map[goncurses.Key]*keyTrie
+}
+
+// typedKey is a key waiting to be handled and whether mappings still apply
+// to it
+type typedKey struct {
+	key   goncurses.Key
+	remap bool
+}
+
+// names understood between < and > in mappings
+var keyNames = map[string]goncurses.Key{
+	"esc": 27, "cr": goncurses.KEY_RETURN, "enter": goncurses.KEY_RETURN,
+	"return": goncurses.KEY_RETURN, "nl": goncurses.KEY_RETURN,
+	"tab": goncurses.KEY_TAB, "space": ' ', "bs": goncurses.KEY_BACKSPACE,
+	"lt": '<', "bar": '|', "bslash": '\\', "del": goncurses.KEY_DC,
+	"up": goncurses.KEY_UP, "down": goncurses.KEY_DOWN,
+	"left": goncurses.KEY_LEFT, "right": goncurses.KEY_RIGHT,
+	"home": goncurses.KEY_HOME, "end": goncurses.KEY_END,
+	"pageup": goncurses.KEY_PAGEUP, "pagedown": goncurses.KEY_PAGEDOWN,
+	"insert": goncurses.KEY_IC,
+}
+
+// ParseKeys reads key notation such as jk, <Esc> or <C-w>l. A < that does
+// not start a known name is just <.
+func ParseKeys(text string) []goncurses.Key {
+	var keys []goncurses.Key
+	for text != "" {
+		if text[0] == '<' {
+			if end := strings.IndexByte(text, '>'); end > 1 {
+				if key, ok := namedKey(strings.ToLower(text[1:end])); ok {
+					keys = append(keys, key)
+					text = text[end+1:]
+					continue
+				}
+			}
+		}
+		_, size := utf8.DecodeRuneInString(text)
+		for i := 0; i < size; i++ {
+			keys = append(keys, goncurses.Key(text[i]))
+		}
+		text = text[size:]
+	}
+	return keys
+}
+
+func namedKey(name string) (goncurses.Key, bool) {
+	if key, ok := keyNames[name]; ok {
+		return key, true
+	}
+	if len(name) == 3 && strings.HasPrefix(name, "c-") && name[2] >= '@' && name[2] <= '_'+32 {
+		return goncurses.Key(name[2] & 0x1f), true
+	}
+	return 0, false
+}
+
+// FormatKeys writes keys back in the notation ParseKeys reads
+func FormatKeys(keys []goncurses.Key) string {
+	var b strings.Builder
+	for _, key := range keys {
+		switch {
+		case key == ' ':
+			b.WriteString("<Space>")
+		case key == '<':
+			b.WriteString("<lt>")
+		case key == 27:
+			b.WriteString("<Esc>")
+		case key == goncurses.KEY_RETURN:
+			b.WriteString("<CR>")
+		case key == goncurses.KEY_TAB:
+			b.WriteString("<Tab>")
+		case key < 32:
+			b.WriteString("<C-" + string(rune(key+'`')) + ">")
+		case key < 256:
+			b.WriteByte(byte(key))
+		default:
+			name := "key"
+			for n, k := range keyNames {
+				if k == key {
+					name = n
+				}
+			}
+			b.WriteString("<" + strings.ToUpper(name[:1]) + name[1:] + ">")
+		}
+	}
+	return b.String()
+}
+
+// mapMode is the letter of the mappings used for the next key: n, v or i,
+// or 0 where keys are never mapped. Keys that finish a command, like the
+// character after f or r, are not mapped either.
+func mapMode(s *State) byte {
+	switch s.status {
+	case NORMAL:
+		if s.pending == "" {
+			return 'n'
+		}
+	case VISUAL, VISUAL_LINE:
+		if s.pending == "" {
+			return 'v'
+		}
+	case INSERT, REPLACE:
+		return 'i'
+	}
+	return 0
+}
+
+// AddMapping maps lhs to rhs in each of modes
+func AddMapping(s *State, modes string, lhs []goncurses.Key, m *Mapping) {
+	if s.mappings == nil {
+		s.mappings = make(map[byte]*keyTrie)
+	}
+	for i := 0; i < len(modes); i++ {
+		node := s.mappings[modes[i]]
+		if node == nil {
+			node = &keyTrie{}
+			s.mappings[modes[i]] = node
+		}
+		for _, key := range lhs {
+			if node.next == nil {
+				node.next = make(map[goncurses.Key]*keyTrie)
+			}
+			if node.next[key] == nil {
+				node.next[key] = &keyTrie{}
+			}
+			node = node.next[key]
+		}
+		node.mapping = m
+	}
+}
+
+// RemoveMapping drops the mapping of lhs in each of modes, failing if none
+// of them had one
+func RemoveMapping(s *State, modes string, lhs []goncurses.Key) error {
+	found := false
+	for i := 0; i < len(modes); i++ {
+		node := s.mappings[modes[i]]
+		for _, key := range lhs {
+			if node == nil {
+				break
+			}
+			node = node.next[key]
+		}
+		if node != nil && node.mapping != nil {
+			node.mapping = nil
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("E31: No such mapping")
+	}
+	return nil
+}
+
+// MapCommand is :map and friends. With a left and right-hand side it adds
+// a mapping, with less it lists those starting with lhs.
+func MapCommand(s *State, modes string, noremap bool, args string) error {
+	lhs, rhs, _ := strings.Cut(args, " ")
+	rhs = strings.TrimLeft(rhs, " ")
+	if rhs == "" {
+		return ListMappings(s, modes, ParseKeys(lhs))
+	}
+	AddMapping(s, modes, ParseKeys(lhs), &Mapping{rhs: ParseKeys(rhs), noremap: noremap})
+	return nil
+}
+
+// ListMappings shows the mappings of modes beginning with prefix in the
+// pager, a * marking those that are not remapped
+func ListMappings(s *State, modes string, prefix []goncurses.Key) error {
+	var lines []string
+	var walk func(mode byte, node *keyTrie, lhs []goncurses.Key)
+	walk = func(mode byte, node *keyTrie, lhs []goncurses.Key) {
+		if node.mapping != nil && len(lhs) >= len(prefix) && slices.Equal(lhs[:len(prefix)], prefix) {
+			star := " "
+			if node.mapping.noremap {
+				star = "*"
+			}
+			lines = append(lines, fmt.Sprintf("%c  %-12s %s %s", mode, FormatKeys(lhs), star, FormatKeys(node.mapping.rhs)))
+		}
+		for key, next := range node.next {
+			walk(mode, next, append(slices.Clip(lhs), key))
+		}
+	}
+	for i := 0; i < len(modes); i++ {
+		if node := s.mappings[modes[i]]; node != nil {
+			walk(modes[i], node, nil)
+		}
+	}
+	if len(lines) == 0 {
+		Info(s, "No mapping found")
+		return nil
+	}
+	sort.Strings(lines)
+	s.pager = nil
+	for _, line := range lines {
+		s.pager = append(s.pager, Message{text: line})
+	}
+	s.pagerTop = 0
+	s.status = PAGER
+	return nil
+}
+
+// NextKey is ReadKey with mappings applied. Typed keys are matched against
+// the mappings of the current mode, waiting up to timeoutlen when they
+// could still become a longer one, and a match is replaced by its
+// right-hand side in the keys still to handle.
+func NextKey(s *State, resized <-chan os.Signal, wait time.Duration) goncurses.Key {
+	for {
+		if len(s.typeahead) == 0 {
+			key := ReadKey(s, resized, wait)
+			if key == 0 || key == goncurses.KEY_RESIZE {
+				return key
+			}
+			s.mapDepth = 0
+			s.typeahead = append(s.typeahead, typedKey{key: key, remap: true})
+		}
+		node := s.mappings[mapMode(s)]
+		var match *keyTrie
+		n, matched := 0, 0
+		for node != nil && n < len(s.typeahead) && s.typeahead[n].remap {
+			if node = node.next[s.typeahead[n].key]; node != nil {
+				n++
+				if node.mapping != nil {
+					match, matched = node, n
+				}
+			}
+		}
+		if node != nil && len(node.next) > 0 && n == len(s.typeahead) {
+			// the keys so far could still grow into a longer mapping
+			key := ReadKey(s, resized, s.timeoutlen)
+			if key == goncurses.KEY_RESIZE {
+				return key
+			}
+			if key != 0 {
+				s.typeahead = append(s.typeahead, typedKey{key: key, remap: true})
+				continue
+			}
+		}
+		if match == nil {
+			key := s.typeahead[0].key
+			s.typeahead = s.typeahead[1:]
+			return key
+		}
+		if s.mapDepth++; s.mapDepth > maxMapDepth {
+			s.typeahead = nil
+			Error(s, errors.New("E223: Recursive mapping"))
+			return 0
+		}
+		var rhs []typedKey
+		for _, key := range match.mapping.rhs {
+			rhs = append(rhs, typedKey{key: key, remap: !match.mapping.noremap})
+		}
+		s.typeahead = append(rhs, s.typeahead[matched:]...)
+		if len(s.typeahead) == 0 {
+			return 0
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+)
+
+// number of positions kept in the jump list
+const jumpHistory = 100
+
+// record logs e for undo and remembers where it happened as the . mark
+func (tgb *TextGapBuffer) record(e edit) {
+	tgb.history.record(e)
+	tgb.SetMark('.', e.offset)
+}
+
+// SetMark places mark r at offset
+func (tgb *TextGapBuffer) SetMark(r rune, offset int) {
+	if tgb.marks == nil {
+		tgb.marks = make(map[rune]int)
+	}
+	tgb.marks[r] = offset
+}
+
+// Mark is the offset of mark r and whether it is set
+func (tgb *TextGapBuffer) Mark(r rune) (int, bool) {
+	offset, ok := tgb.marks[r]
+	return offset, ok
+}
+
+// AddJump appends offset to the jump list unless it is already the newest
+// entry, dropping the oldest once the list is full
+func (tgb *TextGapBuffer) AddJump(offset int) {
+	if n := len(tgb.jumps); n > 0 && tgb.jumps[n-1] == offset {
+		return
+	}
+	tgb.jumps = append(tgb.jumps, offset)
+	if len(tgb.jumps) > jumpHistory {
+		tgb.jumps = tgb.jumps[len(tgb.jumps)-jumpHistory:]
+	}
+}
+
+// Jumps is the jump list, oldest first
+func (tgb *TextGapBuffer) Jumps() []int {
+	return tgb.jumps
+}
+
+// MarkLines sets the line marks :g works through, offsets that move with
+// edits like marks do
+func (tgb *TextGapBuffer) MarkLines(offsets []int) {
+	tgb.lines = offsets
+}
+
+// LineMark is where line mark i is now, false once its line was deleted
+func (tgb *TextGapBuffer) LineMark(i int) (int, bool) {
+	if i < 0 || i >= len(tgb.lines) || tgb.lines[i] < 0 {
+		return 0, false
+	}
+	return tgb.lines[i], true
+}
+
+// shiftMarks moves marks, jumps and line marks at or after offset right by n
+// runes
+func (tgb *TextGapBuffer) shiftMarks(offset int, n int) {
+	for r, m := range tgb.marks {
+		if m >= offset {
+			tgb.marks[r] = m + n
+		}
+	}
+	for i, m := range tgb.jumps {
+		if m >= offset {
+			tgb.jumps[i] = m + n
+		}
+	}
+	for i, m := range tgb.lines {
+		if m >= offset {
+			tgb.lines[i] = m + n
+		}
+	}
+}
+
+// dropMarks updates marks, jumps and line marks for the runes in [from, to)
+// being removed: those after them move left, those on a line removed whole
+// are deleted and others inside the range move to its start
+func (tgb *TextGapBuffer) dropMarks(from int, to int) {
+	for r, m := range tgb.marks {
+		if m, ok := tgb.dropped(m, from, to); ok {
+			tgb.marks[r] = m
+		} else {
+			delete(tgb.marks, r)
+		}
+	}
+	jumps := tgb.jumps[:0]
+	for _, m := range tgb.jumps {
+		if m, ok := tgb.dropped(m, from, to); ok && (len(jumps) == 0 || jumps[len(jumps)-1] != m) {
+			jumps = append(jumps, m)
+		}
+	}
+	tgb.jumps = jumps
+	for i, m := range tgb.lines {
+		if m, ok := tgb.dropped(m, from, to); ok && m >= 0 {
+			tgb.lines[i] = m
+		} else {
+			tgb.lines[i] = -1
+		}
+	}
+}
+
+// dropped is where offset m ends up once [from, to) is removed, and false
+// when the whole line it is on goes with it
+func (tgb *TextGapBuffer) dropped(m int, from int, to int) (int, bool) {
+	switch {
+	case m >= to:
+		return m - (to - from), true
+	case m >= from:
+		// look for the line's ends only inside the range, so a mark costs
+		// no more than the runes removed. The end of the buffer ends the
+		// last line as a newline would.
+		start, end := from == 0 || tgb.at(from-1) == '\n', to == tgb.Len()
+		for j := m - 1; j >= from && !start; j-- {
+			start = tgb.at(j) == '\n'
+		}
+		for j := m; j < to && !end; j++ {
+			end = tgb.at(j) == '\n'
+		}
+		if start && end {
+			return 0, false
+		}
+		return from, true
+	}
+	return m, true
+}
+
+// SetUserMark records the cursor position under mark r, a letter or one of
+// the jump marks ' and `
+func SetUserMark(s *State, r rune) error {
+	if r == '`' {
+		r = '\''
+	}
+	if !(r >= 'a' && r <= 'z') && r != '\'' {
+		return errors.New("Invalid mark")
+	}
+	s.buf.SetMark(r, s.buf.Cursor())
+	return nil
+}
+
+// JumpToMark moves to mark r, the exact position for ` and the first
+// non-blank of its line for '. Jumping is itself a jump, so jumping to the
+// ' mark goes back.
+func JumpToMark(s *State, r rune, exact bool) error {
+	if r == '`' {
+		r = '\''
+	}
+	if !unicode.IsLower(r) && r != '\'' && r != '.' {
+		return errors.New("Invalid mark")
+	}
+	offset, ok := s.buf.Mark(r)
+	if !ok {
+		return errors.New("Mark not set")
+	}
+	Jump(s)
+	MoveToOffset(s, offset)
+	if !exact {
+		MoveToLine(s, s.y)
+	}
+	return nil
+}
+
+// Jump remembers the cursor position as the ' mark and in the jump list
+// before a motion that can move far away
+func Jump(s *State) {
+	s.buf.SetMark('\'', s.buf.Cursor())
+	s.buf.AddJump(s.buf.Cursor())
+	s.jump = len(s.buf.Jumps())
+}
+
+// JumpOlder goes n entries back in the jump list, like ctrl-o. Leaving the
+// newest end of the list adds the cursor position first so ctrl-i can come
+// back to it.
+func JumpOlder(s *State, n int) {
+	if s.jump >= len(s.buf.Jumps()) {
+		s.buf.AddJump(s.buf.Cursor())
+		s.jump = len(s.buf.Jumps()) - 1
+	}
+	jumpTo(s, s.jump-n)
+}
+
+// JumpNewer goes n entries forward in the jump list, like ctrl-i
+func JumpNewer(s *State, n int) {
+	jumpTo(s, min(s.jump, len(s.buf.Jumps()))+n)
+}
+
+func jumpTo(s *State, i int) {
+	jumps := s.buf.Jumps()
+	if i < 0 || i >= len(jumps) {
+		s.failed = true
+		return
+	}
+	s.jump = i
+	MoveToOffset(s, jumps[i])
+	Info(s, fmt.Sprintf("jump %d/%d", i+1, len(jumps)))
+}
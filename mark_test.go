@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestMarksOnDeletedText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		mark   int
+		from   int
+		to     int
+		want   int
+		exists bool
+	}{
+		{"after the range", "ab\ncd\nef", 7, 3, 6, 4, true},
+		{"before the range", "ab\ncd\nef", 1, 3, 6, 1, true},
+		{"inside part of a line", "abcd\nef", 2, 1, 3, 1, true},
+		{"on a middle line deleted whole", "ab\ncd\nef", 4, 3, 6, 0, false},
+		{"on the first line deleted whole", "ab\ncd", 1, 0, 3, 0, false},
+		{"on the last line without a newline", "ab\ncd", 4, 2, 5, 0, false},
+		{"on the last line with a newline", "ab\ncd\n", 4, 3, 6, 0, false},
+		{"on the line above the last", "ab\ncd", 1, 2, 5, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, _ := NewTextGapBuffer(tt.text)
+			buf.SetMark('a', tt.mark)
+			if err := buf.DeleteRange(tt.from, tt.to); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := buf.Mark('a')
+			if ok != tt.exists || ok && got != tt.want {
+				t.Errorf("mark at %d, %v, want %d, %v", got, ok, tt.want, tt.exists)
+			}
+		})
+	}
+}
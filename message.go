@@ -0,0 +1,122 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gbin/goncurses"
+)
+
+// Message is a line of feedback shown on the message line
+type Message struct {
+	text string
+	err  bool
+}
+
+// number of messages kept for :messages
+const messageHistory = 100
+
+// how long an info message stays up without a keypress
+const infoTimeout = 4 * time.Second
+
+// Info shows text on the message line until the next key and adds it to
+// the history
+func Info(s *State, text string) {
+	Notify(s, Message{text: text})
+}
+
+// Error shows err like Info but highlighted, a nil err does nothing
+func Error(s *State, err error) {
+	if err != nil {
+		Notify(s, Message{text: err.Error(), err: true})
+	}
+}
+
+// Notify makes m the current message and remembers it, dropping the oldest
+// message once the history is full
+func Notify(s *State, m Message) {
+	s.message = m
+	s.messages = append(s.messages, m)
+	if len(s.messages) > messageHistory {
+		s.messages = s.messages[len(s.messages)-messageHistory:]
+	}
+}
+
+// PrintMessage replaces whatever is on the message line, the bottom row,
+// with m
+func PrintMessage(w *goncurses.Window, s *State, m Message) {
+	maxY, _ := w.MaxYX()
+	w.Move(maxY-1, 0)
+	w.ClearToEOL()
+	if m.err {
+		w.AttrSet(Attr(s, ErrorStyle))
+	}
+	w.Print(m.text)
+	w.AttrSet(goncurses.A_NORMAL)
+}
+
+// Ask shows question on the message line and hands the next key to answer,
+// which can ask again to wait for another
+func Ask(s *State, question string, answer func(*State) error) {
+	s.question = question
+	s.answer = answer
+	s.status = CONFIRM
+	s.incFrom, s.incTo = 0, 0
+}
+
+// HandleConfirm passes the key that answers a question to its handler
+func HandleConfirm(s *State) error {
+	s.status = NORMAL
+	return s.answer(s)
+}
+
+// ShowMessages lists the message history in the pager
+func ShowMessages(s *State) error {
+	s.pager = append([]Message(nil), s.messages...)
+	s.pagerTop = max(len(s.pager)-1, 0)
+	s.status = PAGER
+	return nil
+}
+
+// HandlePager scrolls the pager with j, k, space, b, g and G, any of q,
+// escape or enter closes it
+func HandlePager(s *State) error {
+	switch s.key {
+	case 106, goncurses.KEY_DOWN: // j
+		s.pagerTop++
+	case 107, goncurses.KEY_UP: // k
+		s.pagerTop--
+	case 32, goncurses.KEY_PAGEDOWN: // space
+		s.pagerTop += s.pagerHeight
+	case 98, goncurses.KEY_PAGEUP: // b
+		s.pagerTop -= s.pagerHeight
+	case 103: // g
+		s.pagerTop = 0
+	case 71: // G
+		s.pagerTop = len(s.pager)
+	case 113, 27, goncurses.KEY_RETURN, goncurses.KEY_ENTER: // q
+		s.status = s.pagerExit
+		s.pagerExit = NORMAL
+		s.pager = nil
+	}
+	return nil
+}
+
+// PrintPager draws the pager list over the whole window, scrolled so the
+// last page never starts past the end of the list, with a prompt on the
+// bottom row
+func PrintPager(w *goncurses.Window, s *State) {
+	maxY, _ := w.MaxYX()
+	s.pagerHeight = maxY - 1
+	s.pagerTop = max(min(s.pagerTop, len(s.pager)-s.pagerHeight), 0)
+	for row := 0; row < s.pagerHeight && s.pagerTop+row < len(s.pager); row++ {
+		m := s.pager[s.pagerTop+row]
+		if m.err {
+			w.AttrSet(Attr(s, ErrorStyle))
+		}
+		w.MovePrint(row, 0, m.text)
+		w.AttrSet(goncurses.A_NORMAL)
+	}
+	w.AttrSet(Attr(s, PagerStyle))
+	w.MovePrint(maxY-1, 0, "-- j/k scroll, q quits --")
+	w.AttrSet(goncurses.A_NORMAL)
+}
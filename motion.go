@@ -0,0 +1,428 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/gbin/goncurses"
+)
+
+// HandleMotion moves the cursor for motion keys shared by NORMAL and VISUAL
+// mode and reports whether the key was a motion
+func HandleMotion(s *State) bool {
+	n := Count(s)
+	if s.pending != "" {
+		switch keys := s.pending + string(rune(s.key)); {
+		case keys == "gg":
+			Jump(s)
+			MoveToLine(s, n-1)
+		case keys == "zz":
+			ScrollCursorTo(s, s.height/2)
+		case keys == "zt":
+			ScrollCursorTo(s, 0)
+		case keys == "zb":
+			ScrollCursorTo(s, s.height-1)
+		case s.pending == "'" || s.pending == "`":
+			if s.key != 27 { // escape cancels
+				Error(s, JumpToMark(s, rune(s.key), s.pending == "`"))
+			}
+		case len(s.pending) == 1 && strings.Contains("fFtT", s.pending):
+			r, ok := AssembleRune(s)
+			if Partial(s) {
+				return true // wait for the rest of the character
+			}
+			if ok && s.key != 27 { // escape cancels
+				s.lastFind = Find{cmd: rune(s.pending[0]), char: r}
+				FindMotion(s, s.lastFind, n, false)
+			}
+		default:
+			return false
+		}
+		s.pending = ""
+		return true
+	}
+	switch s.key {
+	case 104, goncurses.KEY_LEFT: // h
+		MoveX(s, -n)
+	case 106, goncurses.KEY_DOWN: // j
+		MoveY(s, n)
+	case 107, goncurses.KEY_UP: // k
+		MoveY(s, -n)
+	case 108, goncurses.KEY_RIGHT: // l
+		MoveX(s, n)
+	case 48, goncurses.KEY_HOME: // 0
+		MoveToColumn(s, 0)
+	case 94: // ^
+		MoveToColumn(s, min(s.buf.Indent(), s.buf.LineLength()-1))
+	case 36, goncurses.KEY_END: // $
+		MoveToColumn(s, s.buf.LineLength()-1)
+	case 6, goncurses.KEY_PAGEDOWN: // ctrl-f
+		ScrollPages(s, n)
+	case 2, goncurses.KEY_PAGEUP: // ctrl-b
+		ScrollPages(s, -n)
+	case 72: // H
+		Jump(s)
+		MoveY(s, min(s.topLine+n-1, BottomLine(s))-s.y)
+	case 77: // M
+		Jump(s)
+		MoveY(s, (s.topLine+BottomLine(s))/2-s.y)
+	case 76: // L
+		Jump(s)
+		MoveY(s, max(BottomLine(s)-n+1, s.topLine)-s.y)
+	case 125: // }
+		Jump(s)
+		MoveToOffset(s, repeat(ParagraphForward, s.buf, n))
+	case 123: // {
+		Jump(s)
+		MoveToOffset(s, repeat(ParagraphBackward, s.buf, n))
+	case 37: // %
+		Jump(s)
+		if s.count > 0 {
+			MoveToLine(s, (s.count*s.buf.LineCount()+99)/100-1)
+		} else if offset := MatchBracket(s.buf, s.buf.Cursor()); offset != -1 {
+			MoveToOffset(s, offset)
+		} else {
+			s.failed = true
+		}
+	case 15: // ctrl-o
+		JumpOlder(s, n)
+	case goncurses.KEY_TAB: // ctrl-i
+		JumpNewer(s, n)
+	case 4: // ctrl-d
+		ScrollHalf(s, true)
+	case 21: // ctrl-u
+		ScrollHalf(s, false)
+	case 119: // w
+		MoveToOffset(s, repeat(WordForward, s.buf, n))
+	case 98: // b
+		MoveToOffset(s, repeat(WordBackward, s.buf, n))
+	case 101: // e
+		MoveToOffset(s, repeat(WordEnd, s.buf, n))
+	case 71: // G
+		Jump(s)
+		if s.count > 0 {
+			MoveToLine(s, s.count-1)
+		} else {
+			MoveToLine(s, s.buf.LineCount()-1)
+		}
+	case 103, 122, 102, 70, 116, 84, 39, 96: // g z f F t T ' `
+		s.pending = string(rune(s.key))
+	case 59: // ;
+		FindMotion(s, s.lastFind, n, true)
+	case 44: // ,
+		FindMotion(s, s.lastFind.Reverse(), n, true)
+	default:
+		return false
+	}
+	return true
+}
+
+// InsertMotion moves the cursor for the keypad keys that work in INSERT
+// mode, where the cursor may sit after the last character, and reports
+// whether the key was one of them
+func InsertMotion(s *State) bool {
+	switch s.key {
+	case goncurses.KEY_LEFT:
+		MoveX(s, -1)
+	case goncurses.KEY_RIGHT:
+		MoveX(s, 1)
+	case goncurses.KEY_UP:
+		MoveY(s, -1)
+	case goncurses.KEY_DOWN:
+		MoveY(s, 1)
+	case goncurses.KEY_HOME:
+		MoveToColumn(s, 0)
+	case goncurses.KEY_END:
+		MoveToColumn(s, s.buf.LineLength())
+	case goncurses.KEY_PAGEDOWN:
+		ScrollPages(s, 1)
+	case goncurses.KEY_PAGEUP:
+		ScrollPages(s, -1)
+	default:
+		return false
+	}
+	return true
+}
+
+// ScrollPages moves the view n windows down, or up when n is negative,
+// keeping two lines of the old page in view like vim's ctrl-f and ctrl-b,
+// and brings the cursor along when it scrolls off
+func ScrollPages(s *State, n int) {
+	page := max(s.height-2, 1)
+	s.topLine = max(min(s.topLine+n*page, s.buf.LineCount()-1), 0)
+	y := max(min(s.y, s.topLine+s.height-1), s.topLine)
+	s.failed = y == s.y && n != 0
+	if y != s.y {
+		MoveToLine(s, y)
+	}
+}
+
+// BottomLine is the last buffer line shown in the window
+func BottomLine(s *State) int {
+	return max(min(s.topLine+s.height, s.buf.LineCount())-1, s.topLine)
+}
+
+// ScrollCursorTo scrolls the view so the cursor line lands on the given
+// row of the window, as far as that is possible without showing anything
+// before the first line or after the last one
+func ScrollCursorTo(s *State, row int) {
+	s.topLine = max(min(s.y-row, s.buf.LineCount()-s.height), 0)
+}
+
+// ScrollHalf moves the view and the cursor half a window down, or up, so
+// the cursor keeps its row on screen. A count scrolls that many lines
+// instead. The view stops at the ends of the buffer while the cursor goes on
+// to the first or last line.
+func ScrollHalf(s *State, down bool) {
+	n := max(s.height/2, 1)
+	if s.count > 0 {
+		n = s.count
+	}
+	if down {
+		s.topLine = max(min(s.topLine+n, s.buf.LineCount()-s.height), s.topLine)
+		MoveY(s, n)
+	} else {
+		s.topLine = max(s.topLine-n, 0)
+		MoveY(s, -n)
+	}
+}
+
+// MoveToColumn puts the cursor at column x of the current line, clamped to
+// the line
+func MoveToColumn(s *State, x int) {
+	s.buf.ChangeCursorPosition(s.y, 0)
+	s.x = max(min(x, LastColumn(s, s.buf.LineLength())), 0)
+	s.buf.ChangeCursorPosition(s.y, s.x)
+}
+
+// repeat applies an offset motion n times starting at the cursor
+func repeat(motion func(TextBuffer, int) int, buf TextBuffer, n int) int {
+	offset := buf.Cursor()
+	for i := 0; i < n; i++ {
+		offset = motion(buf, offset)
+	}
+	return offset
+}
+
+// MoveToLine jumps to the first non-blank character of line y
+func MoveToLine(s *State, y int) {
+	s.y = max(min(y, s.buf.LineCount()-1), 0)
+	s.buf.SetCursor(s.buf.LineStart(s.y))
+	MoveToColumn(s, min(s.buf.Indent(), s.buf.LineLength()-1))
+}
+
+// MoveToOffset puts the cursor on the rune at offset
+func MoveToOffset(s *State, offset int) {
+	s.buf.SetCursor(offset)
+	SyncCursor(s)
+}
+
+// character classes for word motions, like vim's iskeyword
+const (
+	blank = iota
+	punct
+	word
+)
+
+func charClass(r rune) int {
+	switch {
+	case unicode.IsSpace(r):
+		return blank
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return word
+	}
+	return punct
+}
+
+// emptyLine reports whether offset is the newline of an empty line
+func emptyLine(buf TextBuffer, i int) bool {
+	return buf.RuneAt(i) == '\n' && (i == 0 || buf.RuneAt(i-1) == '\n')
+}
+
+// ParagraphForward finds the next empty line after the paragraph at offset
+// i, a run of empty lines counting as one, or the end of the last line
+func ParagraphForward(buf TextBuffer, i int) int {
+	start, end := buf.LineBounds(i)
+	for end < buf.Len() && emptyLine(buf, start) {
+		start, end = buf.LineBounds(end)
+	}
+	for end < buf.Len() {
+		start, end = buf.LineBounds(end)
+		if emptyLine(buf, start) {
+			return start
+		}
+	}
+	_, last := LineExtent(buf, start)
+	return max(last-1, start)
+}
+
+// ParagraphBackward finds the empty line before the paragraph at offset i,
+// or the start of the buffer
+func ParagraphBackward(buf TextBuffer, i int) int {
+	start, _ := buf.LineBounds(i)
+	for start > 0 && emptyLine(buf, start) {
+		start, _ = buf.LineBounds(start - 1)
+	}
+	for start > 0 {
+		start, _ = buf.LineBounds(start - 1)
+		if emptyLine(buf, start) {
+			return start
+		}
+	}
+	return 0
+}
+
+// WordForward finds the start of the next word after offset i, counting
+// empty lines as words
+func WordForward(buf TextBuffer, i int) int {
+	n := buf.Len()
+	if cls := charClass(buf.RuneAt(i)); cls != blank {
+		for i < n && charClass(buf.RuneAt(i)) == cls {
+			i++
+		}
+	}
+	for i < n && charClass(buf.RuneAt(i)) == blank {
+		if buf.RuneAt(i) == '\n' && emptyLine(buf, i+1) {
+			return i + 1
+		}
+		i++
+	}
+	return max(min(i, n-1), 0)
+}
+
+// WordBackward finds the start of the word before offset i
+func WordBackward(buf TextBuffer, i int) int {
+	i--
+	for i > 0 && charClass(buf.RuneAt(i)) == blank && !emptyLine(buf, i) {
+		i--
+	}
+	if i <= 0 || emptyLine(buf, i) {
+		return max(i, 0)
+	}
+	cls := charClass(buf.RuneAt(i))
+	for i > 0 && charClass(buf.RuneAt(i-1)) == cls {
+		i--
+	}
+	return i
+}
+
+// WordEnd finds the last rune of the word after offset i
+func WordEnd(buf TextBuffer, i int) int {
+	n := buf.Len()
+	i++
+	for i < n && charClass(buf.RuneAt(i)) == blank {
+		i++
+	}
+	if i >= n {
+		return max(n-1, 0)
+	}
+	cls := charClass(buf.RuneAt(i))
+	for i+1 < n && charClass(buf.RuneAt(i+1)) == cls {
+		i++
+	}
+	return i
+}
+
+// Find is a character search on the current line started with f, F, t or T
+type Find struct {
+	cmd  rune
+	char rune
+}
+
+// Reverse is the same search in the other direction, for ,
+func (f Find) Reverse() Find {
+	switch f.cmd {
+	case 'f':
+		f.cmd = 'F'
+	case 'F':
+		f.cmd = 'f'
+	case 't':
+		f.cmd = 'T'
+	case 'T':
+		f.cmd = 't'
+	}
+	return f
+}
+
+// FindChar looks for the nth occurrence of f.char on the line containing
+// offset, landing on it for f/F or next to it for t/T. When repeating, a t
+// or T search skips the match right next to the cursor.
+func FindChar(buf TextBuffer, offset int, f Find, n int, repeating bool) (int, bool) {
+	start, end := LineExtent(buf, offset)
+	step, stop := 1, 0
+	if f.cmd == 'F' || f.cmd == 'T' {
+		step = -1
+	}
+	if f.cmd == 't' || f.cmd == 'T' {
+		stop = -step
+	}
+	i := offset
+	if repeating && stop != 0 && buf.RuneAt(offset+step) == f.char {
+		i += step
+	}
+	for n > 0 {
+		i += step
+		if i < start || i >= end {
+			return offset, false
+		}
+		if buf.RuneAt(i) == f.char {
+			n--
+		}
+	}
+	return i + stop, true
+}
+
+// FindMotion moves the cursor for f, F, t, T, ; and , and leaves it alone if
+// the character is not found
+func FindMotion(s *State, f Find, n int, repeating bool) {
+	if f.cmd == 0 {
+		return
+	}
+	offset, ok := FindChar(s.buf, s.buf.Cursor(), f, n, repeating)
+	if ok {
+		MoveToOffset(s, offset)
+	}
+	s.failed = !ok
+}
+
+// bracket pairs % jumps between, openers map to closers and back
+var brackets = map[rune]rune{'(': ')', '[': ']', '{': '}', ')': '(', ']': '[', '}': '{'}
+
+// MatchBracket is the offset of the bracket matching the one at or after
+// offset on its line, taking nesting into account, or -1 if there is no
+// bracket or it is unbalanced
+func MatchBracket(buf TextBuffer, offset int) int {
+	_, end := LineExtent(buf, offset)
+	for ; offset < end; offset++ {
+		if _, ok := brackets[buf.RuneAt(offset)]; ok {
+			break
+		}
+	}
+	if offset == end {
+		return -1
+	}
+	return matchAt(buf, offset)
+}
+
+// matchAt is the offset of the bracket matching the one at offset, or -1 if
+// it is unbalanced
+func matchAt(buf TextBuffer, offset int) int {
+	open := buf.RuneAt(offset)
+	close := brackets[open]
+	step := 1
+	if strings.ContainsRune(")]}", open) {
+		step = -1
+	}
+	depth := 0
+	for i := offset; i >= 0 && i < buf.Len(); i += step {
+		switch buf.RuneAt(i) {
+		case open:
+			depth++
+		case close:
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option is a setting changed with :set. Exactly one of flag, number, text
+// and duration points it at the State field it lives in, durations being
+// set in milliseconds. Numbers below least are refused.
+type Option struct {
+	name     string
+	short    string
+	flag     func(*State) *bool
+	number   func(*State) *int
+	text     func(*State) *string
+	duration func(*State) *time.Duration
+	least    int
+	values   []string // the only values a text option takes, if set
+	check    func(string) error
+	changed  func(*State) // called after the option is set
+	// for a flag kept elsewhere than on State, how to read and change it
+	get func(*State) bool
+	set func(*State, bool)
+}
+
+// options is every option :set knows, in the order :set all lists them
+var options = []Option{
+	{name: "autoindent", short: "ai", flag: func(s *State) *bool { return &s.autoindent }},
+	{name: "autosave", short: "as", flag: func(s *State) *bool { return &s.autosave }},
+	{name: "autosavetime", short: "ast", duration: func(s *State) *time.Duration { return &s.autosavetime }},
+	{name: "backup", short: "bk", flag: func(s *State) *bool { return &s.backup }},
+	{name: "backupdir", short: "bdir", text: func(s *State) *string { return &s.backupdir }},
+	{name: "bomb", flag: func(s *State) *bool { return &s.format.bomb }},
+	{name: "commentstring", short: "cms", text: func(s *State) *string { return &s.commentstring }},
+	{name: "cursorline", short: "cul", flag: func(s *State) *bool { return &s.cursorline }},
+	{name: "debug", flag: func(s *State) *bool { return &s.debug }},
+	{name: "endofline", short: "eol", flag: func(s *State) *bool { return &s.format.endofline }},
+	{name: "expandtab", short: "et", flag: func(s *State) *bool { return &s.expandtab }},
+	{
+		name: "fileencoding", short: "fenc", values: encodings,
+		text: func(s *State) *string { return &s.format.encoding },
+	},
+	{
+		name: "fileformat", short: "ff", values: fileformats,
+		text: func(s *State) *string { return &s.format.fileformat },
+	},
+	{name: "fixendofline", short: "fixeol", flag: func(s *State) *bool { return &s.fixeol }},
+	{
+		name: "hlsearch", short: "hls", changed: func(s *State) { s.nohlsearch = false },
+		flag: func(s *State) *bool { return &s.hlsearch },
+	},
+	{name: "ignorecase", short: "ic", flag: func(s *State) *bool { return &s.ignorecase }},
+	{name: "list", flag: func(s *State) *bool { return &s.list }},
+	{
+		name: "listchars", short: "lcs",
+		text:  func(s *State) *string { return &s.listchars },
+		check: func(value string) error { _, err := ParseListChars(value); return err },
+	},
+	{name: "number", short: "nu", flag: func(s *State) *bool { return &s.number }},
+	{
+		name: "readonly", short: "ro",
+		get: func(s *State) bool { return s.buf.ReadOnly() },
+		set: func(s *State, on bool) { s.buf.SetReadOnly(on) },
+	},
+	{name: "relativenumber", short: "rnu", flag: func(s *State) *bool { return &s.relative }},
+	{name: "scrolloff", short: "so", number: func(s *State) *int { return &s.scrolloff }},
+	{name: "shiftwidth", short: "sw", number: func(s *State) *int { return &s.shiftwidth }, least: 1},
+	{name: "smartcase", short: "scs", flag: func(s *State) *bool { return &s.smartcase }},
+	{name: "tabstop", short: "ts", number: func(s *State) *int { return &s.tabstop }, least: 1},
+	{
+		name: "theme", values: themeNames, changed: LoadTheme,
+		text: func(s *State) *string { return &s.theme },
+	},
+	{name: "timeoutlen", short: "tm", duration: func(s *State) *time.Duration { return &s.timeoutlen }},
+	{name: "trimtrailingws", short: "ttw", flag: func(s *State) *bool { return &s.trimws }},
+	{name: "ttimeoutlen", short: "ttm", duration: func(s *State) *time.Duration { return &s.ttimeout }},
+	{name: "undofile", short: "udf", flag: func(s *State) *bool { return &s.undofile }},
+	{name: "wrap", flag: func(s *State) *bool { return &s.wrap }},
+}
+
+// FindOption looks an option up by its name or short name
+func FindOption(name string) (*Option, bool) {
+	for i := range options {
+		if o := &options[i]; name == o.name || name != "" && name == o.short {
+			return o, true
+		}
+	}
+	return nil, false
+}
+
+func (o *Option) isFlag() bool {
+	return o.flag != nil || o.get != nil
+}
+
+func (o *Option) enabled(s *State) bool {
+	if o.get != nil {
+		return o.get(s)
+	}
+	return *o.flag(s)
+}
+
+// enable sets or clears a flag and lets the option react to it
+func (o *Option) enable(s *State, on bool) {
+	if o.set != nil {
+		o.set(s, on)
+	} else {
+		*o.flag(s) = on
+	}
+	if o.changed != nil {
+		o.changed(s)
+	}
+}
+
+// Show is the option as :set name? prints it, name=value or, for flags,
+// name or noname
+func (o *Option) Show(s *State) string {
+	switch {
+	case o.isFlag() && o.enabled(s):
+		return "  " + o.name
+	case o.isFlag():
+		return "no" + o.name
+	case o.number != nil:
+		return fmt.Sprintf("  %s=%d", o.name, *o.number(s))
+	case o.duration != nil:
+		return fmt.Sprintf("  %s=%d", o.name, *o.duration(s)/time.Millisecond)
+	}
+	return fmt.Sprintf("  %s=%s", o.name, *o.text(s))
+}
+
+// assign parses value, the text after = in arg, into a number or text
+// option
+func (o *Option) assign(s *State, arg string, value string) error {
+	if o.text != nil {
+		if o.values != nil && !slices.Contains(o.values, value) {
+			return errors.New("Invalid argument: " + arg)
+		}
+		if o.check != nil {
+			if err := o.check(value); err != nil {
+				return err
+			}
+		}
+		*o.text(s) = value
+		return nil
+	}
+	if o.isFlag() {
+		return errors.New("Invalid argument: " + arg)
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return errors.New("Invalid argument: " + arg)
+	}
+	if n < o.least {
+		return errors.New("Argument must be positive: " + arg)
+	}
+	if o.number != nil {
+		*o.number(s) = n
+	} else {
+		*o.duration(s) = time.Duration(n) * time.Millisecond
+	}
+	return nil
+}
+
+// SetOption is :set. Each argument sets a flag with name, clears it with
+// noname, toggles it with name! or invname, gives an option a value with
+// name=value and shows it with name?, or with just the name for options
+// that are not flags. Without arguments, or with all, every option is
+// listed.
+func SetOption(s *State, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) == 1 && fields[0] == "all" {
+		return ListOptions(s)
+	}
+	for _, arg := range fields {
+		if err := setOne(s, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setOne(s *State, arg string) error {
+	name, value, assign := strings.Cut(arg, "=")
+	if !assign {
+		name, value, assign = strings.Cut(arg, ":")
+	}
+	if o, ok := FindOption(name); ok && assign {
+		if err := o.assign(s, arg, value); err != nil {
+			return err
+		}
+		if o.changed != nil {
+			o.changed(s)
+		}
+		return nil
+	}
+	if o, ok := FindOption(strings.TrimSuffix(name, "?")); ok && !assign {
+		if strings.HasSuffix(name, "?") || !o.isFlag() {
+			Info(s, o.Show(s))
+			return nil
+		}
+		o.enable(s, true)
+		return nil
+	}
+	var toggle bool
+	switch {
+	case assign:
+	case strings.HasSuffix(name, "!"):
+		name, toggle = strings.TrimSuffix(name, "!"), true
+	case strings.HasPrefix(name, "inv"):
+		name, toggle = strings.TrimPrefix(name, "inv"), true
+	case strings.HasPrefix(name, "no"):
+		if o, ok := FindOption(name[2:]); ok && o.isFlag() {
+			o.enable(s, false)
+			return nil
+		}
+	}
+	if o, ok := FindOption(name); ok && toggle {
+		if !o.isFlag() {
+			return errors.New("Invalid argument: " + arg)
+		}
+		o.enable(s, !o.enabled(s))
+		return nil
+	}
+	return errors.New("Unknown option: " + name)
+}
+
+// ListOptions shows every option and its value in the pager
+func ListOptions(s *State) error {
+	s.pager = nil
+	for i := range options {
+		s.pager = append(s.pager, Message{text: options[i].Show(s)})
+	}
+	s.pagerTop = 0
+	s.status = PAGER
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gbin/goncurses"
+)
+
+// isTerminal reports whether f is a terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ReadStdin reads the text piped in for gotext -
+func ReadStdin() (*TextGapBuffer, Format, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, defaultFormat, err
+	}
+	text, f := DecodeText(data, "")
+	buf, err := NewTextGapBuffer(text)
+	return buf, f, err
+}
+
+// InitScreen starts curses. When stdin or stdout is a pipe, because the
+// editor sits in the middle of a pipeline, it talks to the terminal through
+// /dev/tty instead.
+func InitScreen() (*goncurses.Window, error) {
+	if isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+		return goncurses.Init()
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := goncurses.NewTerm("", tty, tty); err != nil {
+		return nil, err
+	}
+	return goncurses.StdScr(), nil
+}
+
+// WriteCommand runs command in the shell with the buffer on its stdin, like
+// :w !cmd. Its output goes to the editor's stdout, so with a pipe there it
+// carries on down the pipeline, and on the terminal it stays up until enter
+// is pressed. In batch mode there is no screen to step away from.
+func WriteCommand(s *State, command string) error {
+	if s.window != nil {
+		goncurses.End()
+		defer s.window.Refresh()
+	}
+	c := exec.Command("sh", "-c", command)
+	c.Stdin = strings.NewReader(s.buf.ReadAll())
+	c.Stdout, c.Stderr = os.Stdout, os.Stderr
+	err := c.Run()
+	if s.window != nil && isTerminal(os.Stdout) {
+		fmt.Print("\nPress ENTER to continue")
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			tty.Read(make([]byte, 1))
+			tty.Close()
+		}
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return fmt.Errorf("shell returned %d", exit.ExitCode())
+	}
+	return err
+}
+
+// Finish ends curses and, with --stdout, prints the text last written from
+// the first buffer, or the text it was opened with
+func Finish(s *State) {
+	goncurses.End()
+	if s.stdout {
+		os.Stdout.WriteString(s.output)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Register holds yanked or deleted text
+type Register struct {
+	text     string
+	linewise bool
+}
+
+// unnamed register used when no register is selected with "
+const unnamed = '"'
+
+// SelectRegister handles the key after a " prefix and reports whether it
+// named a register
+func SelectRegister(s *State) bool {
+	r := rune(s.key)
+	if r < 128 && (unicode.IsLetter(r) || r == unnamed) {
+		s.regName = r
+		return true
+	}
+	Info(s, "Invalid register name")
+	return false
+}
+
+// SetRegister stores r in the unnamed register and in the selected one, an
+// uppercase name appends to the lowercase register
+func SetRegister(s *State, r Register) {
+	if s.registers == nil {
+		s.registers = make(map[rune]Register)
+	}
+	name := s.regName
+	switch {
+	case name == 0 || name == unnamed:
+	case unicode.IsUpper(name):
+		name = unicode.ToLower(name)
+		old := s.registers[name]
+		if old.linewise && !r.linewise {
+			r.text = old.text + r.text + "\n"
+		} else if !old.linewise && r.linewise && old.text != "" {
+			r.text = old.text + "\n" + r.text
+		} else {
+			r.text = old.text + r.text
+		}
+		r.linewise = r.linewise || old.linewise
+		s.registers[name] = r
+	default:
+		s.registers[name] = r
+	}
+	s.registers[unnamed] = r
+}
+
+// GetRegister returns the selected register, or the unnamed one
+func GetRegister(s *State) Register {
+	if s.regName == 0 {
+		return s.registers[unnamed]
+	}
+	return GetNamedRegister(s, s.regName)
+}
+
+// GetNamedRegister returns register name, ignoring case
+func GetNamedRegister(s *State, name rune) Register {
+	return s.registers[unicode.ToLower(name)]
+}
+
+// YankLines copies n lines starting at the cursor's line into the register
+func YankLines(s *State, n int) {
+	start, end := LinesBounds(s.buf, s.buf.Cursor(), n)
+	r := Register{text: s.buf.Slice(start, end), linewise: true}
+	if !strings.HasSuffix(r.text, "\n") {
+		r.text += "\n"
+	}
+	SetRegister(s, r)
+}
+
+// Put inserts the register n times after the cursor, or before it when
+// before is set. Line-wise text goes below or above the current line instead.
+func Put(s *State, before bool, n int) error {
+	r := GetRegister(s)
+	if r.text == "" {
+		return nil
+	}
+	cursor := s.buf.Cursor()
+	text := strings.Repeat(r.text, n)
+	var offset, landing int
+	if r.linewise {
+		start, end := s.buf.LineBounds(cursor)
+		offset = start
+		if !before {
+			offset = end
+			if end == s.buf.Len() && (end == 0 || s.buf.Slice(end-1, end) != "\n") {
+				// last line has no newline to insert after
+				text = "\n" + strings.TrimSuffix(text, "\n")
+				landing = 1
+			}
+		}
+	} else {
+		offset = cursor
+		if !before && cursor < s.buf.Len() && s.buf.Slice(cursor, cursor+1) != "\n" {
+			offset++
+		}
+		landing = utf8.RuneCountInString(text) - 1
+	}
+	if err := s.buf.SetCursor(offset); err != nil {
+		return err
+	}
+	if err := s.buf.Write(text); err != nil {
+		return err
+	}
+	err := s.buf.SetCursor(offset + landing)
+	SyncCursor(s)
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gbin/goncurses"
+)
+
+// TrackChange remembers the keys of the last command that changed the
+// buffer, including a whole insert session, so . can replay them
+func TrackChange(s *State) {
+	switch s.status {
+	case INSERT, REPLACE:
+		s.changing = true
+		return
+	case NORMAL:
+		if s.pending != "" || s.count != 0 || s.regName != 0 {
+			return // command not finished yet
+		}
+	default:
+		s.changing = false
+		return
+	}
+	if len(s.change) > 0 && s.change[0] == 58 { // : commands are not repeatable
+		s.changing = false
+		s.change = nil
+		return
+	}
+	if s.changing || (s.buf.Version() != s.version && s.key != 46) { // .
+		s.lastChange = s.change
+	}
+	s.changing = false
+	s.change = nil
+}
+
+// Repeat replays the last change, a count replaces the one it was typed with
+func Repeat(s *State) error {
+	keys := s.lastChange
+	if s.count > 0 {
+		keys = withCount(keys, s.count)
+	}
+	s.count, s.regName = 0, 0
+	s.replaying = true
+	key := s.key
+	defer func() { s.replaying, s.key = false, key }()
+	for _, k := range keys {
+		s.key = k
+		if err := dispatch(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withCount swaps the count at the start of keys, after any register
+// prefix, for n
+func withCount(keys []goncurses.Key, n int) []goncurses.Key {
+	i := 0
+	if len(keys) > 1 && keys[0] == 34 { // "
+		i = 2
+	}
+	j := i
+	for j < len(keys) && keys[j] >= 48 && keys[j] <= 57 {
+		j++
+	}
+	out := append([]goncurses.Key{}, keys[:i]...)
+	for _, d := range fmt.Sprint(n) {
+		out = append(out, goncurses.Key(d))
+	}
+	return append(out, keys[j:]...)
+}
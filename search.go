@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gbin/goncurses"
+)
+
+// HandleSearch edits the / prompt and runs the search on Enter
+func HandleSearch(s *State) error {
+	switch s.key {
+	case 27: // escape
+		s.status = NORMAL
+		s.topLine, s.leftCol = s.searchTop, s.searchLeft
+	case goncurses.KEY_RETURN, goncurses.KEY_ENTER:
+		s.status = NORMAL
+		s.searchForward = s.promptPrefix == "/"
+		return Search(s, s.prompt, s.searchForward)
+	default:
+		EditPrompt(s)
+	}
+	IncrementalSearch(s)
+	return nil
+}
+
+// EditPrompt applies backspace and printable keys to the prompt text,
+// backspacing over an empty prompt closes it
+func EditPrompt(s *State) {
+	switch s.key {
+	case goncurses.KEY_BACKSPACE, 127:
+		if s.prompt == "" {
+			s.status = NORMAL
+		} else {
+			r := []rune(s.prompt)
+			s.prompt = string(r[:len(r)-1])
+		}
+	default:
+		if r, ok := AssembleRune(s); ok && unicode.IsPrint(r) {
+			s.prompt += string(r)
+		}
+	}
+}
+
+// IncrementalSearch finds the match of the pattern typed so far without
+// moving the cursor, so it can be highlighted until Enter commits it. The
+// view goes back to where it was when the prompt opened, to be scrolled to
+// the new match if there is one.
+func IncrementalSearch(s *State) {
+	s.incFrom, s.incTo = 0, 0
+	s.topLine, s.leftCol = s.searchTop, s.searchLeft
+	if s.prompt == "" {
+		return
+	}
+	// a pattern still being typed may not compile yet
+	re, err := CompilePattern(s, s.prompt)
+	if err != nil {
+		return
+	}
+	from, to, _ := findFrom(s.buf, re, s.buf.Cursor(), s.promptPrefix == "/")
+	if from != -1 {
+		s.incFrom, s.incTo = from, to
+	}
+}
+
+// ScrollToMatch scrolls the view like ScrollToCursor but to the match of the
+// incremental search, leaving the cursor where it is
+func ScrollToMatch(s *State, height int, width int) {
+	cursor, y, x := s.buf.Cursor(), s.y, s.x
+	s.buf.SetCursor(s.incFrom)
+	SyncCursor(s)
+	ScrollToCursor(s, height, width)
+	s.buf.SetCursor(cursor)
+	s.y, s.x = y, x
+}
+
+// findFrom looks for a match of re after or before cursor, wrapping around
+// the ends of the buffer, and reports whether it wrapped
+func findFrom(buf TextBuffer, re *regexp.Regexp, cursor int, forward bool) (int, int, bool) {
+	if forward {
+		if from, to := buf.Find(re, min(cursor+1, buf.Len())); from != -1 {
+			return from, to, false
+		}
+		from, to := buf.Find(re, 0)
+		return from, to, true
+	}
+	if from, to := buf.FindBefore(re, cursor); from != -1 {
+		return from, to, false
+	}
+	from, to := buf.FindBefore(re, buf.Len())
+	return from, to, true
+}
+
+// CompilePattern turns a search pattern, a Go regexp, into the regexp that
+// matches it with case ignored as SearchCase decides. The last search is
+// compiled once and reused until a change of options makes it match
+// differently, as hlsearch needs it for every line drawn.
+func CompilePattern(s *State, pattern string) (*regexp.Regexp, error) {
+	expr, fold := SearchCase(s, pattern)
+	if fold {
+		expr = "(?i)" + expr
+	}
+	if s.searchRE != nil && s.searchRE.String() == expr {
+		return s.searchRE, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err == nil && pattern == s.lastSearch {
+		s.searchRE = re
+	}
+	return re, err
+}
+
+// SearchCase drops \c and \C from pattern and reports whether it matches
+// ignoring case. \c always ignores case and \C never does, otherwise case
+// is ignored with ignorecase unless smartcase is also set and the pattern
+// has an uppercase letter.
+func SearchCase(s *State, pattern string) (string, bool) {
+	lower, upper := strings.Contains(pattern, `\c`), strings.Contains(pattern, `\C`)
+	pattern = strings.ReplaceAll(strings.ReplaceAll(pattern, `\c`, ""), `\C`, "")
+	switch {
+	case lower:
+		return pattern, true
+	case upper:
+		return pattern, false
+	}
+	return pattern, s.ignorecase && !(s.smartcase && strings.ContainsFunc(pattern, unicode.IsUpper))
+}
+
+// StartSearch opens the search prompt, prefix is / or ?
+func StartSearch(s *State, prefix string) {
+	s.status = SEARCH
+	s.promptPrefix = prefix
+	s.prompt = ""
+	s.searchTop, s.searchLeft = s.topLine, s.leftCol
+}
+
+// Search moves the cursor to the next occurrence of pattern after it, or
+// before it when searching backwards, wrapping around the ends of the
+// buffer. An empty pattern repeats the last search.
+func Search(s *State, pattern string, forward bool) error {
+	if pattern == "" {
+		pattern = s.lastSearch
+	}
+	if pattern == "" {
+		Info(s, "No previous search")
+		return nil
+	}
+	re, err := CompilePattern(s, pattern)
+	if err != nil {
+		return err
+	}
+	s.lastSearch = pattern
+	s.nohlsearch = false
+	_, fold := SearchCase(s, pattern)
+	// echo the search, marked \c when the options made it ignore case
+	s.message = Message{text: "/" + pattern}
+	if !forward {
+		s.message.text = "?" + pattern
+	}
+	if fold && !strings.Contains(pattern, `\c`) {
+		s.message.text += `\c`
+	}
+	offset, _, wrapped := findFrom(s.buf, re, s.buf.Cursor(), forward)
+	if offset == -1 {
+		return errors.New("Pattern not found: " + pattern)
+	}
+	if wrapped && forward {
+		Info(s, "search hit BOTTOM, continuing at TOP")
+	} else if wrapped {
+		Info(s, "search hit TOP, continuing at BOTTOM")
+	}
+	Jump(s)
+	MoveToOffset(s, offset)
+	return nil
+}
+
+// SearchNext repeats the last search, in the opposite direction if reverse
+// is set
+func SearchNext(s *State, reverse bool) error {
+	return Search(s, "", s.searchForward != reverse)
+}
+
+// SearchMatches marks the runes of a line of text inside a match of the
+// last search, for hlsearch to highlight
+func SearchMatches(s *State, text []rune) func(int) bool {
+	none := func(int) bool { return false }
+	if !s.hlsearch || s.nohlsearch || s.lastSearch == "" {
+		return none
+	}
+	re, err := CompilePattern(s, s.lastSearch)
+	if err != nil {
+		return none
+	}
+	line := string(text)
+	var matches [][2]int
+	for _, m := range re.FindAllStringIndex(line, -1) {
+		from := utf8.RuneCountInString(line[:m[0]])
+		matches = append(matches, [2]int{from, from + utf8.RuneCountInString(line[m[0]:m[1]])})
+	}
+	return func(i int) bool {
+		for _, m := range matches {
+			if i >= m[0] && i < m[1] {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SearchWord searches for the word under the cursor, or the next one on the
+// line, as a whole word with * and #
+func SearchWord(s *State, forward bool) error {
+	start, end := LineExtent(s.buf, s.buf.Cursor())
+	i := s.buf.Cursor()
+	for i < end && charClass(s.buf.RuneAt(i)) != word {
+		i++
+	}
+	if i == end {
+		return errors.New("No string under cursor")
+	}
+	for i > start && charClass(s.buf.RuneAt(i-1)) == word {
+		i--
+	}
+	j := i
+	for j < end && charClass(s.buf.RuneAt(j)) == word {
+		j++
+	}
+	// \b only knows ASCII word characters
+	pattern := regexp.QuoteMeta(s.buf.Slice(i, j))
+	if s.buf.RuneAt(i) < utf8.RuneSelf {
+		pattern = `\b` + pattern
+	}
+	if s.buf.RuneAt(j-1) < utf8.RuneSelf {
+		pattern += `\b`
+	}
+	s.searchForward = forward
+	return Search(s, pattern, forward)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompilePatternReusesLastSearch(t *testing.T) {
+	s := newTestState(t, "Foo foo")
+	s.lastSearch = "foo"
+	re, err := CompilePattern(s, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := CompilePattern(s, "foo"); again != re {
+		t.Error("the last search was compiled again")
+	}
+	s.ignorecase = true
+	folded, _ := CompilePattern(s, "foo")
+	if folded == re || !folded.MatchString("FOO") {
+		t.Errorf("with ignorecase got %v, want a new regexp ignoring case", folded)
+	}
+	if other, _ := CompilePattern(s, "bar"); other == s.searchRE {
+		t.Error("a pattern other than the last search replaced it")
+	}
+}
+
+func TestIncrementalSearchScrollsToMatch(t *testing.T) {
+	s := newTestState(t, strings.Repeat("x\n", 50)+"match\n")
+	StartSearch(s, "/")
+	s.prompt = "match"
+	IncrementalSearch(s)
+	ScrollToMatch(s, 10, 40)
+	if s.topLine != 41 {
+		t.Errorf("topLine = %d, want 41 to show the match on the last row", s.topLine)
+	}
+	if s.buf.Cursor() != 0 || s.y != 0 {
+		t.Errorf("cursor moved to %d on line %d, want it left at 0", s.buf.Cursor(), s.y)
+	}
+	s.prompt = ""
+	IncrementalSearch(s)
+	if s.topLine != 0 {
+		t.Errorf("topLine = %d after emptying the pattern, want 0", s.topLine)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Counts are the statistics g ctrl-g reports for some text
+type Counts struct {
+	lines int
+	words int
+	chars int
+	bytes int
+}
+
+// CountText counts the text in [from, to) straight from the buffer. Words
+// are the runs w moves between and a last line without a newline counts.
+func CountText(buf TextBuffer, from int, to int) Counts {
+	var c Counts
+	prev := blank
+	for i := from; i < to; i++ {
+		r := buf.RuneAt(i)
+		cls := charClass(r)
+		if cls != blank && cls != prev {
+			c.words++
+		}
+		if r == '\n' {
+			c.lines++
+		}
+		prev = cls
+		c.chars++
+		c.bytes += utf8.RuneLen(r)
+	}
+	if to > from && buf.RuneAt(to-1) != '\n' {
+		c.lines++
+	}
+	return c
+}
+
+// ShowStats reports the size of the buffer and how far into it the cursor
+// is, or the size of the selection in VISUAL mode, like g ctrl-g
+func ShowStats(s *State) error {
+	total := CountText(s.buf, 0, s.buf.Len())
+	if s.status == VISUAL || s.status == VISUAL_LINE {
+		from, to := s.buf.Selection()
+		sel := CountText(s.buf, from, to)
+		Info(s, fmt.Sprintf("Selected %d of %d Lines; %d of %d Words; %d of %d Chars; %d of %d Bytes",
+			sel.lines, total.lines, sel.words, total.words, sel.chars, total.chars, sel.bytes, total.bytes))
+		return nil
+	}
+	c := s.buf.Cursor()
+	upto := CountText(s.buf, 0, min(c+1, s.buf.Len()))
+	Info(s, fmt.Sprintf("Col %d of %d; Line %d of %d; Word %d of %d; Char %d of %d; Byte %d of %d",
+		Column(s)+1, s.buf.LineLength(), s.y+1, s.buf.LineCount(), upto.words, total.words,
+		upto.chars, total.chars, upto.bytes, total.bytes))
+	return nil
+}
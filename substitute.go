@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Substitute runs /pattern/replacement/flags over lines from to to. The
+// pattern is a Go regexp, \1 and $1 both refer to groups in the
+// replacement while any other $ is taken as it is, and the flags g (every
+// match on a line), i and I (ignore case or not, whatever ignorecase says)
+// and c (confirm each one) are supported.
+func Substitute(s *State, from int, to int, args string) error {
+	pattern, replacement, flags, err := splitSubstitute(args)
+	if err != nil {
+		return err
+	}
+	expr := pattern
+	if strings.Contains(flags, "i") {
+		expr += `\c`
+	} else if strings.Contains(flags, "I") {
+		expr += `\C`
+	}
+	re, err := CompilePattern(s, expr)
+	if err != nil {
+		return err
+	}
+	global := strings.Contains(flags, "g")
+	if strings.Contains(flags, "c") {
+		sub := &substitution{
+			re: re, replacement: convertReplacement(re, replacement), global: global,
+			y: from, to: to, lastLine: -1, origin: s.buf.Cursor(),
+			question: "replace with " + replacement + " (y/n/a/q/l)?",
+		}
+		if !sub.find(s) {
+			return errors.New("Pattern not found: " + pattern)
+		}
+		sub.ask(s)
+		return nil
+	}
+	replacement = convertReplacement(re, replacement)
+
+	subs, lines, lastLine := 0, 0, -1
+	for y := from; y <= to; y++ {
+		start, end := LineExtent(s.buf, s.buf.LineStart(y))
+		line := s.buf.Slice(start, end)
+		matches := re.FindAllStringSubmatchIndex(line, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		if !global {
+			matches = matches[:1]
+		}
+		var out []byte
+		prev := 0
+		for _, m := range matches {
+			out = append(out, line[prev:m[0]]...)
+			out = re.ExpandString(out, replacement, line, m)
+			prev = m[1]
+		}
+		out = append(out, line[prev:]...)
+		if err := s.buf.ReplaceRange(start, end, string(out)); err != nil {
+			return err
+		}
+		subs += len(matches)
+		lines++
+		lastLine = y
+	}
+	if subs == 0 {
+		return errors.New("Pattern not found: " + pattern)
+	}
+	MoveToLine(s, lastLine)
+	Info(s, fmt.Sprintf("%d substitutions on %d lines", subs, lines))
+	return nil
+}
+
+// splitSubstitute breaks /pattern/replacement/flags apart, using whatever
+// character follows the s as the delimiter
+func splitSubstitute(args string) (string, string, string, error) {
+	if args == "" {
+		return "", "", "", errors.New("Missing pattern")
+	}
+	parts := splitDelimited(args, 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	if parts[0] == "" {
+		return "", "", "", errors.New("Empty pattern")
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// splitDelimited splits what follows the delimiter args starts with into at
+// most n parts, like vim does for :s and :g. A backslash before the
+// delimiter makes it part of the text and any other escape is kept for the
+// pattern, except in the last part, which is left as it was typed.
+func splitDelimited(args string, n int) []string {
+	delim, size := utf8.DecodeRuneInString(args)
+	text := []rune(args[size:])
+	var parts []string
+	var part []rune
+	i := 0
+	for ; i < len(text) && len(parts) < n-1; i++ {
+		switch {
+		case text[i] == '\\' && i+1 < len(text) && text[i+1] == delim:
+			part = append(part, delim)
+			i++
+		case text[i] == '\\' && i+1 < len(text):
+			part = append(part, text[i], text[i+1])
+			i++
+		case text[i] == delim:
+			parts, part = append(parts, string(part)), nil
+		default:
+			part = append(part, text[i])
+		}
+	}
+	if len(parts) < n-1 {
+		return append(parts, string(part))
+	}
+	return append(parts, string(text[i:]))
+}
+
+var backref = regexp.MustCompile(`\\[0-9]|\$[0-9]*`)
+
+// convertReplacement turns \1 and $1 group references into the ${1} form
+// regexp.Expand understands and escapes every other $, so a $ or a
+// reference to a group re does not have stays in the text as typed
+func convertReplacement(re *regexp.Regexp, replacement string) string {
+	return backref.ReplaceAllStringFunc(replacement, func(ref string) string {
+		if n, err := strconv.Atoi(ref[1:]); err == nil && n <= re.NumSubexp() {
+			return fmt.Sprintf("${%d}", n)
+		}
+		return strings.ReplaceAll(ref, "$", "$$")
+	})
+}
+
+// substitution is a :s with the c flag working through its matches, asking
+// about each one. The edits all stay pending in the buffer's history while
+// it asks, so they undo together.
+type substitution struct {
+	re          *regexp.Regexp
+	replacement string
+	global      bool
+	question    string
+	// where to look for the next match, the line and a byte offset in it,
+	// and the last line
+	y   int
+	col int
+	to  int
+	// the match being asked about, in submatch indexes of line, which starts
+	// at offset start
+	line  string
+	start int
+	match []int
+	// substitutions made and on how many lines, the last of them, and the
+	// cursor to go back to when nothing was replaced
+	subs     int
+	lines    int
+	lastLine int
+	origin   int
+}
+
+// find moves on to the next match, reporting whether there is one
+func (sub *substitution) find(s *State) bool {
+	for ; sub.y <= sub.to && sub.y < s.buf.LineCount(); sub.y, sub.col = sub.y+1, 0 {
+		start, end := LineExtent(s.buf, s.buf.LineStart(sub.y))
+		line := s.buf.Slice(start, end)
+		for k, m := range sub.re.FindAllStringSubmatchIndex(line, -1) {
+			if !sub.global && k > 0 {
+				break
+			}
+			if m[0] >= sub.col {
+				sub.line, sub.start, sub.match = line, start, m
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ask moves the cursor to the match and highlights it while asking
+func (sub *substitution) ask(s *State) {
+	from := sub.start + utf8.RuneCountInString(sub.line[:sub.match[0]])
+	MoveToOffset(s, from)
+	Ask(s, sub.question, sub.answer)
+	s.incFrom, s.incTo = from, from+utf8.RuneCountInString(sub.line[sub.match[0]:sub.match[1]])
+}
+
+// answer handles y, n, a, q and l, asking again after any other key
+func (sub *substitution) answer(s *State) error {
+	switch s.key {
+	case 121: // y
+		if err := sub.replace(s); err != nil {
+			return sub.finish(s, err)
+		}
+	case 110: // n
+		sub.skip(sub.match[1])
+	case 97: // a
+		for {
+			if err := sub.replace(s); err != nil {
+				return sub.finish(s, err)
+			}
+			if !sub.find(s) {
+				return sub.finish(s, nil)
+			}
+		}
+	case 108: // l
+		return sub.finish(s, sub.replace(s))
+	case 113, 27: // q, escape
+		return sub.finish(s, nil)
+	default:
+		sub.ask(s)
+		return nil
+	}
+	if !sub.find(s) {
+		return sub.finish(s, nil)
+	}
+	sub.ask(s)
+	return nil
+}
+
+// replace substitutes the current match and moves past the replacement
+func (sub *substitution) replace(s *State) error {
+	m := sub.match
+	out := string(sub.re.ExpandString(nil, sub.replacement, sub.line, m))
+	from := sub.start + utf8.RuneCountInString(sub.line[:m[0]])
+	to := from + utf8.RuneCountInString(sub.line[m[0]:m[1]])
+	if err := s.buf.ReplaceRange(from, to, out); err != nil {
+		return err
+	}
+	sub.subs++
+	if sub.lastLine != sub.y {
+		sub.lines++
+		sub.lastLine = sub.y
+	}
+	sub.line = sub.line[:m[0]] + out + sub.line[m[1]:]
+	sub.skip(m[0] + len(out))
+	return nil
+}
+
+// skip continues the search at byte offset col of the line, or on the next
+// line without the g flag. After an empty match it steps over a character
+// so the same spot does not match again.
+func (sub *substitution) skip(col int) {
+	if !sub.global {
+		sub.y, sub.col = sub.y+1, 0
+		return
+	}
+	if sub.match[0] == sub.match[1] && col < len(sub.line) {
+		_, size := utf8.DecodeRuneInString(sub.line[col:])
+		col += size
+	} else if sub.match[0] == sub.match[1] {
+		sub.y, sub.col = sub.y+1, 0
+		return
+	}
+	sub.col = col
+}
+
+// finish stops asking and reports what was done, or err
+func (sub *substitution) finish(s *State, err error) error {
+	s.incFrom, s.incTo = 0, 0
+	if sub.lastLine >= 0 {
+		MoveToLine(s, sub.lastLine)
+	} else {
+		MoveToOffset(s, sub.origin)
+	}
+	if err != nil {
+		return err
+	}
+	Info(s, fmt.Sprintf("%d substitutions on %d lines", sub.subs, sub.lines))
+	return nil
+}
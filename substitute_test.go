@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestSubstituteReplacement(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{`/(\w+) (\w+)/\2 \1/`, "bar foo 10"},
+		{`/(\w+) (\w+)/$2 $1/`, "bar foo 10"},
+		{`/foo/\0\0/`, "foofoo bar 10"},
+		{`/(foo)/$5/`, "$5 bar 10"},
+		{`/(foo)/\5/`, `\5 bar 10`},
+		{`/10/$$/`, "foo bar $$"},
+		{`/10/$/`, "foo bar $"},
+		{`/10/$name/`, "foo bar $name"},
+		{`/(1)0/${1}/`, "foo bar ${1}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			s := newTestState(t, "foo bar 10")
+			if err := Substitute(s, 0, 0, tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.buf.ReadAll(); got != tt.want {
+				t.Errorf(":s%s gave %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitSubstitute(t *testing.T) {
+	tests := []struct {
+		args        string
+		pattern     string
+		replacement string
+		flags       string
+	}{
+		{"/a/b/g", "a", "b", "g"},
+		{"/a/b", "a", "b", ""},
+		{"/a", "a", "", ""},
+		{`/a\/b/c/`, "a/b", "c", ""},
+		{`/a/b\/c/g`, "a", "b/c", "g"},
+		{`#a\#b#c#`, "a#b", "c", ""},
+		{`/\d+\./x/`, `\d+\.`, "x", ""},
+		{`/a\\/b/`, `a\\`, "b", ""},
+		{"/a/b/c/d", "a", "b", "c/d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			pattern, replacement, flags, err := splitSubstitute(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pattern != tt.pattern || replacement != tt.replacement || flags != tt.flags {
+				t.Errorf("splitSubstitute(%q) = %q, %q, %q, want %q, %q, %q", tt.args, pattern, replacement, flags, tt.pattern, tt.replacement, tt.flags)
+			}
+		})
+	}
+}
+
+func TestSubstituteEscapedDelimiter(t *testing.T) {
+	s := newTestState(t, "a/b a/b")
+	if err := Substitute(s, 0, 0, `/a\/b/c\/d/g`); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.buf.ReadAll(); got != "c/d c/d" {
+		t.Errorf(`:s/a\/b/c\/d/g gave %q, want "c/d c/d"`, got)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gbin/goncurses"
+)
+
+// HandleSurround finishes the ys, cs and ds commands once all their keys
+// have arrived: ys<object><char> wraps a text object, ys<motion><char> the
+// text the motion moves over and yss the line, cs<old><new> swaps the pair
+// around the cursor and ds<char> removes it
+func HandleSurround(s *State, keys string) error {
+	if s.key == 27 || s.key > 127 { // escape cancels
+		return nil
+	}
+	switch {
+	case len(keys) == 2:
+		s.pending = keys
+	case keys[0] == 'd':
+		return DeleteSurround(s, rune(keys[2]))
+	case keys[0] == 'c' && len(keys) == 3:
+		s.pending = keys
+	case keys[0] == 'c':
+		return ChangeSurround(s, rune(keys[2]), rune(keys[3]))
+	case len(keys) == 3:
+		s.pending = keys // wait for the pair, or the object after i or a
+	case keys[2] == 's':
+		start, end := LineExtent(s.buf, s.buf.Cursor())
+		return Surround(s, start+s.buf.Indent(), end, rune(keys[3]))
+	case keys[2] != 'i' && keys[2] != 'a':
+		return SurroundMotion(s, goncurses.Key(keys[2]), rune(keys[3]))
+	case len(keys) == 4:
+		s.pending = keys
+	case len(keys) == 5:
+		from, to, ok := SelectObject(s, rune(keys[3]), keys[2] == 'a', Count(s))
+		if !ok {
+			s.failed = true
+			return nil
+		}
+		if keys[2] == 'a' {
+			// the whitespace aw takes stays outside the pair
+			text := s.buf.Slice(from, to)
+			from += len([]rune(text)) - len([]rune(strings.TrimLeft(text, " \t")))
+			to -= len([]rune(text)) - len([]rune(strings.TrimRight(text, " \t")))
+		}
+		return Surround(s, from, to, rune(keys[4]))
+	default:
+		s.failed = true
+	}
+	return nil
+}
+
+// SurroundMotion wraps the text from the cursor to where motion moves it in
+// the pair for c. Like an operator, $, e and % take in the character they
+// stop on, and whitespace at the end of the text stays outside the pair.
+func SurroundMotion(s *State, motion goncurses.Key, c rune) error {
+	origin, key := s.buf.Cursor(), s.key
+	s.key = motion
+	moved := HandleMotion(s)
+	s.key = key
+	if !moved || s.pending != "" || s.failed {
+		s.pending, s.failed = "", true
+		MoveToOffset(s, origin)
+		return nil
+	}
+	from, to := origin, s.buf.Cursor()
+	if to < from {
+		from, to = to, from
+	} else if strings.ContainsRune("$e%", rune(motion)) {
+		to = min(to+1, s.buf.Len())
+	}
+	text := s.buf.Slice(from, to)
+	to -= len([]rune(text)) - len([]rune(strings.TrimRight(text, " \t\n")))
+	if to <= from {
+		s.failed = true
+		MoveToOffset(s, origin)
+		return nil
+	}
+	return Surround(s, from, to, c)
+}
+
+// SurroundSelection wraps the selection in the pair for the key after S,
+// leaving the newline at the end of a line-wise selection outside it
+func SurroundSelection(s *State) error {
+	s.pending = ""
+	if s.key == 27 || s.key > 127 { // escape cancels
+		return nil
+	}
+	from, to := s.buf.Selection()
+	if s.status == VISUAL_LINE && to > from && s.buf.RuneAt(to-1) == '\n' {
+		to--
+	}
+	if err := EndVisual(s); err != nil {
+		return err
+	}
+	return Surround(s, from, to, rune(s.key))
+}
+
+// SurroundPair is the text put before and after for a surround character.
+// An opening bracket adds a space inside the pair, a closing one does not,
+// and anything else is put on both sides.
+func SurroundPair(c rune) (string, string) {
+	switch c {
+	case '(':
+		return "( ", " )"
+	case ')', 'b':
+		return "(", ")"
+	case '{':
+		return "{ ", " }"
+	case '}', 'B':
+		return "{", "}"
+	case '[':
+		return "[ ", " ]"
+	case ']':
+		return "[", "]"
+	case '<', '>':
+		return "<", ">"
+	}
+	return string(c), string(c)
+}
+
+// Surround wraps the text in [from, to) in the pair for c and leaves the
+// cursor on the opening character
+func Surround(s *State, from int, to int, c rune) error {
+	open, close := SurroundPair(c)
+	if err := s.buf.ReplaceRange(to, to, close); err != nil {
+		return err
+	}
+	if err := s.buf.ReplaceRange(from, from, open); err != nil {
+		return err
+	}
+	MoveToOffset(s, from)
+	return nil
+}
+
+// FindSurround finds the quotes or brackets named by c around the cursor,
+// the same pair as the i text object, and returns the offsets of the
+// opening and closing characters
+func FindSurround(s *State, c rune) (int, int, bool) {
+	if c == 'w' {
+		return 0, 0, false
+	}
+	from, to, ok := SelectObject(s, c, false, 1)
+	if !ok {
+		return 0, 0, false
+	}
+	return from - 1, to, true
+}
+
+// DeleteSurround removes the pair named by c around the cursor. An opening
+// bracket also removes the spaces just inside the pair.
+func DeleteSurround(s *State, c rune) error {
+	return ReplaceSurround(s, c, "", "")
+}
+
+// ChangeSurround swaps the pair named by old around the cursor for the pair
+// for c
+func ChangeSurround(s *State, old rune, c rune) error {
+	open, close := SurroundPair(c)
+	return ReplaceSurround(s, old, open, close)
+}
+
+// ReplaceSurround puts open and close in place of the pair named by c
+// around the cursor, leaving the cursor on the opening one
+func ReplaceSurround(s *State, c rune, open string, close string) error {
+	from, to, ok := FindSurround(s, c)
+	if !ok {
+		s.failed = true
+		return nil
+	}
+	inner, end := from+1, to
+	if strings.ContainsRune("({[", c) {
+		for inner < end && s.buf.RuneAt(inner) == ' ' {
+			inner++
+		}
+		for end > inner && s.buf.RuneAt(end-1) == ' ' {
+			end--
+		}
+	}
+	if err := s.buf.ReplaceRange(end, to+1, close); err != nil {
+		return err
+	}
+	if err := s.buf.ReplaceRange(from, inner, open); err != nil {
+		return err
+	}
+	MoveToOffset(s, from)
+	return nil
+}
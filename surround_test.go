@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gbin/goncurses"
+)
+
+// surround types keys after a y the way HandlePending passes them on
+func surround(t *testing.T, s *State, keys string) {
+	t.Helper()
+	s.pending = "y"
+	for _, r := range keys {
+		s.key = goncurses.Key(r)
+		pending := s.pending
+		s.pending = ""
+		if err := HandleSurround(s, pending+string(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSurroundMotion(t *testing.T) {
+	tests := []struct {
+		keys   string
+		text   string
+		cursor int
+		want   string
+	}{
+		{"sw)", "foo bar", 0, "(foo) bar"},
+		{"sw)", "foo\nbar", 0, "(foo)\nbar"},
+		{`s$"`, "say hi there\nnext", 4, `say "hi there"` + "\nnext"},
+		{"se]", "one two", 4, "one [two]"},
+		{"sb}", "one two", 6, "one {tw}o"},
+		{"siw(", "a word here", 3, "a ( word ) here"},
+		{"ss'", "  line", 3, "  'line'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keys, func(t *testing.T) {
+			s := newTestState(t, tt.text)
+			s.buf.SetCursor(tt.cursor)
+			SyncCursor(s)
+			surround(t, s, tt.keys)
+			if got := s.buf.ReadAll(); got != tt.want {
+				t.Errorf("ys%s gave %q, want %q", tt.keys, got, tt.want)
+			}
+			if s.pending != "" {
+				t.Errorf("pending = %q after the pair, want nothing", s.pending)
+			}
+		})
+	}
+}
+
+func TestSurroundMotionFails(t *testing.T) {
+	s := newTestState(t, "foo")
+	surround(t, s, "sh)")
+	if got := s.buf.ReadAll(); got != "foo" || !s.failed {
+		t.Errorf("ysh) at column 0 gave %q, failed %v, want the text unchanged and a failure", got, s.failed)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the swap file is brought up to date after this long without a key, or
+// after this many keys, like vim's updatetime and updatecount
+const (
+	updateTime  = 4 * time.Second
+	updateCount = 200
+)
+
+// largest number of line pairs the recovery diff compares
+const maxDiffCells = 1_000_000
+
+// Swap keeps the swap file of the buffer up to date from a goroutine of its
+// own so writing it never holds up the input loop
+type Swap struct {
+	path    string
+	ops     chan swapOp
+	done    chan struct{}
+	keys    int    // keys handled since the last update
+	version [2]int // buffer version and undo state at the last update
+}
+
+// swapOp is a write of text to the swap file, or its removal
+type swapOp struct {
+	text   string
+	remove bool
+}
+
+// SwapPath is the swap file for the file at path, a hidden .swp file next
+// to it
+func SwapPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".swp")
+}
+
+// StartSwap starts the swap writer for the file at path
+func StartSwap(path string) *Swap {
+	sw := &Swap{path: path, ops: make(chan swapOp, 1), done: make(chan struct{})}
+	go func() {
+		defer close(sw.done)
+		for op := range sw.ops {
+			if op.remove {
+				os.Remove(sw.path)
+			} else {
+				WriteFile(sw.path, op.text)
+			}
+		}
+	}()
+	return sw
+}
+
+// send queues op for the writer, replacing one it has not got to yet
+func (sw *Swap) send(op swapOp) {
+	select {
+	case sw.ops <- op:
+	default:
+		select {
+		case <-sw.ops:
+		default:
+		}
+		sw.ops <- op
+	}
+}
+
+// Close removes the swap file and waits for the writer to finish
+func (sw *Swap) Close() {
+	sw.send(swapOp{remove: true})
+	close(sw.ops)
+	<-sw.done
+}
+
+// swapVersion identifies the text in the buffer, it changes on every edit,
+// undo and redo
+func swapVersion(s *State) [2]int {
+	return [2]int{s.buf.Version(), CurrentChange(s.buf)}
+}
+
+// UpdateSwap writes the unsaved buffer to the swap file once enough keys
+// have gone by or the user has been idle, and removes it once nothing is
+// left unsaved
+func UpdateSwap(s *State, idle bool) {
+	sw := s.swap
+	if sw == nil {
+		return
+	}
+	if !idle {
+		if sw.keys++; sw.keys < updateCount {
+			return
+		}
+	}
+	sw.keys = 0
+	if v := swapVersion(s); v != sw.version {
+		sw.version = v
+		if s.buf.Modified() {
+			sw.send(swapOp{text: s.buf.ReadAll()})
+		} else {
+			sw.send(swapOp{remove: true})
+		}
+	}
+}
+
+// SwapPending reports whether the buffer has changed since the swap file was
+// last brought up to date
+func SwapPending(s *State) bool {
+	return s.swap != nil && swapVersion(s) != s.swap.version
+}
+
+// SwapSaved removes the swap file after the buffer has been written
+func SwapSaved(s *State) {
+	if s.swap != nil {
+		s.swap.version = swapVersion(s)
+		s.swap.send(swapOp{remove: true})
+	}
+}
+
+// the choices offered when a swap file is found
+const recoverPrompt = "Swap file found: (r)ecover, (d)elete it, (v)iew diff, (e)dit anyway, (q)uit"
+
+// CheckSwap looks for a swap file left behind for the file being edited
+// and asks what to do with it before editing starts
+func CheckSwap(s *State) error {
+	if s.filename == "" {
+		return nil
+	}
+	path := SwapPath(s.filename)
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		s.swap = StartSwap(path)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	Ask(s, recoverPrompt, HandleRecover)
+	return nil
+}
+
+// HandleRecover handles the answer to the swap file prompt
+func HandleRecover(s *State) error {
+	path := SwapPath(s.filename)
+	switch s.key {
+	case 114: // r
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := s.buf.ReplaceRange(0, s.buf.Len(), string(data)); err != nil {
+			return err
+		}
+		MoveToOffset(s, 0)
+		Info(s, "Recovered from "+path+", write the file to keep it")
+	case 100: // d
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	case 118: // v
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		ShowDiff(s, s.buf.ReadAll(), string(data))
+		s.pagerExit = CONFIRM
+		return nil
+	case 101: // e
+	case 113, 27: // q
+		s.quit = true
+		return nil
+	default:
+		Ask(s, recoverPrompt, HandleRecover)
+		return nil
+	}
+	s.swap = StartSwap(path)
+	return nil
+}
+
+// ShowDiff shows in the pager how the lines of new differ from old, with
+// removed lines marked - and added ones +
+func ShowDiff(s *State, old string, new string) {
+	a, b := strings.Split(old, "\n"), strings.Split(new, "\n")
+	s.pager = nil
+	s.pagerTop = 0
+	s.status = PAGER
+	if len(a)*len(b) > maxDiffCells {
+		s.pager = append(s.pager, Message{text: "Files are too large to compare"})
+		return
+	}
+	// lcs[i][j] is the longest common run of lines of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			s.pager = append(s.pager, Message{text: "  " + a[i]})
+			i, j = i+1, j+1
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			s.pager = append(s.pager, Message{text: "+ " + b[j], err: true})
+			j++
+		default:
+			s.pager = append(s.pager, Message{text: "- " + a[i], err: true})
+			i++
+		}
+	}
+}
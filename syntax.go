@@ -0,0 +1,205 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/gbin/goncurses"
+)
+
+// Span is a run of runes [from, to) in a line drawn in style
+type Span struct {
+	from  int
+	to    int
+	style Style
+}
+
+// LineState is what a highlighter carries from the end of one line to the
+// start of the next, such as being inside a block comment. 0 is the state
+// at the top of the file.
+type LineState int
+
+// Highlighter colors the text of one language a line at a time. It is
+// given the state the line above ended in and returns the spans of the
+// line and the state it ends in.
+type Highlighter interface {
+	Highlight(line string, state LineState) ([]Span, LineState)
+}
+
+// HighlighterFor picks the highlighter for a file by its extension, nil
+// for file types that are shown as plain text
+func HighlighterFor(filename string) Highlighter {
+	switch filepath.Ext(filename) {
+	case ".go":
+		return GoSyntax{}
+	}
+	return nil
+}
+
+// SyntaxCache holds the state at the start of each line of a document
+// from the top down to the last one worked out, and the offset each of
+// those lines starts at
+type SyntaxCache struct {
+	states []LineState
+	starts []int
+}
+
+// LineStart is the state line y starts in, working out the lines above it
+// that are not cached yet by walking on from the last cached one. Edits
+// first drop the lines after the one they touched.
+func (c *SyntaxCache) LineStart(buf TextBuffer, hl Highlighter, y int) LineState {
+	if offset, ok := buf.Changed(); ok {
+		n, found := slices.BinarySearch(c.starts, offset)
+		if found {
+			n++
+		}
+		c.states, c.starts = c.states[:n], c.starts[:n]
+	}
+	if len(c.states) == 0 {
+		c.states, c.starts = append(c.states, 0), append(c.starts, 0)
+	}
+	for len(c.states) <= y {
+		above := len(c.states) - 1
+		start, end := LineExtent(buf, c.starts[above])
+		_, state := hl.Highlight(buf.Slice(start, end), c.states[above])
+		c.states, c.starts = append(c.states, state), append(c.starts, min(end+1, buf.Len()))
+	}
+	return c.states[y]
+}
+
+// SyntaxAttr gives the attribute of each rune of line y for its syntax,
+// A_NORMAL outside any highlighted span or without a highlighter
+func SyntaxAttr(s *State, y int, text string) func(int) goncurses.Char {
+	hl := HighlighterFor(s.filename)
+	if hl == nil {
+		return func(int) goncurses.Char { return goncurses.A_NORMAL }
+	}
+	spans, _ := hl.Highlight(text, s.syntax.LineStart(s.buf, hl, y))
+	return func(i int) goncurses.Char {
+		for _, span := range spans {
+			if i >= span.from && i < span.to {
+				return Attr(s, span.style)
+			}
+		}
+		return goncurses.A_NORMAL
+	}
+}
+
+// GoSyntax highlights Go keywords, strings, comments and numbers
+type GoSyntax struct{}
+
+// states a Go line can end in besides 0
+const (
+	inBlockComment LineState = iota + 1
+	inRawString
+)
+
+var goKeywords = []string{
+	"break", "case", "chan", "const", "continue", "default", "defer", "else",
+	"fallthrough", "for", "func", "go", "goto", "if", "import", "interface",
+	"map", "package", "range", "return", "select", "struct", "switch", "type",
+	"var",
+}
+
+var goConstants = []string{"true", "false", "nil", "iota"}
+
+func (GoSyntax) Highlight(line string, state LineState) ([]Span, LineState) {
+	text := []rune(line)
+	var spans []Span
+	add := func(from int, to int, style Style) {
+		spans = append(spans, Span{from: from, to: to, style: style})
+	}
+	// index of the first close after from, or -1
+	find := func(from int, close string) int {
+		if i := strings.Index(string(text[from:]), close); i >= 0 {
+			return from + len([]rune(string(text[from:])[:i]))
+		}
+		return -1
+	}
+	i := 0
+	switch state {
+	case inBlockComment:
+		end := find(0, "*/")
+		if end < 0 {
+			add(0, len(text), CommentStyle)
+			return spans, inBlockComment
+		}
+		add(0, end+2, CommentStyle)
+		i = end + 2
+	case inRawString:
+		end := find(0, "`")
+		if end < 0 {
+			add(0, len(text), StringStyle)
+			return spans, inRawString
+		}
+		add(0, end+1, StringStyle)
+		i = end + 1
+	}
+	for i < len(text) {
+		r := text[i]
+		next := rune(0)
+		if i+1 < len(text) {
+			next = text[i+1]
+		}
+		switch {
+		case r == '/' && next == '/':
+			add(i, len(text), CommentStyle)
+			return spans, 0
+		case r == '/' && next == '*':
+			end := find(i+2, "*/")
+			if end < 0 {
+				add(i, len(text), CommentStyle)
+				return spans, inBlockComment
+			}
+			add(i, end+2, CommentStyle)
+			i = end + 2
+		case r == '`':
+			end := find(i+1, "`")
+			if end < 0 {
+				add(i, len(text), StringStyle)
+				return spans, inRawString
+			}
+			add(i, end+1, StringStyle)
+			i = end + 1
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(text) && text[end] != r {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(text))
+			add(i, end, StringStyle)
+			i = end
+		case unicode.IsDigit(r) || r == '.' && unicode.IsDigit(next):
+			end := i + 1
+			for end < len(text) && (isWordRune(text[end]) || text[end] == '.') {
+				end++
+			}
+			add(i, end, ConstantStyle)
+			i = end
+		case isWordRune(r):
+			end := i + 1
+			for end < len(text) && isWordRune(text[end]) {
+				end++
+			}
+			switch word := string(text[i:end]); {
+			case slices.Contains(goKeywords, word):
+				add(i, end, KeywordStyle)
+			case slices.Contains(goConstants, word):
+				add(i, end, ConstantStyle)
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return spans, 0
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
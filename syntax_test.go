@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestSyntaxCacheAfterEdits(t *testing.T) {
+	buf, _ := NewTextGapBuffer("a\n/* b\nc */\nd\n`e\nf`\ng")
+	var c SyntaxCache
+	check := func(want ...LineState) {
+		t.Helper()
+		for y := len(want) - 1; y >= 0; y-- {
+			if got := c.LineStart(buf, GoSyntax{}, y); got != want[y] {
+				t.Errorf("%q: line %d starts in %d, want %d", buf.ReadAll(), y, got, want[y])
+			}
+		}
+	}
+	check(0, 0, inBlockComment, 0, 0, inRawString, 0)
+	// closing the comment early leaves c plain text
+	buf.SetCursor(6)
+	buf.Write(" */")
+	check(0, 0, 0, 0, 0, inRawString, 0)
+	// dropping the first line moves every line up one
+	buf.DeleteRange(0, 2)
+	check(0, 0, 0, 0, inRawString, 0)
+	// an edit at the start of a line keeps the state it starts in
+	buf.SetCursor(buf.LineStart(4))
+	buf.Write("//")
+	check(0, 0, 0, 0, inRawString, 0)
+}
@@ -0,0 +1,127 @@
+package main
+
+// SelectObject finds the text object named obj around the cursor, n times
+// over, for an operator or VISUAL mode. around includes the surrounding
+// whitespace like aw rather than only the object like iw.
+func SelectObject(s *State, obj rune, around bool, n int) (int, int, bool) {
+	switch obj {
+	case 'w':
+		return WordObject(s.buf, s.buf.Cursor(), around, n)
+	case '"', '\'', '`':
+		return QuoteObject(s.buf, s.buf.Cursor(), obj, around)
+	case '(', ')', 'b':
+		return BracketObject(s.buf, s.buf.Cursor(), '(', around, n)
+	case '{', '}', 'B':
+		return BracketObject(s.buf, s.buf.Cursor(), '{', around, n)
+	case '[', ']':
+		return BracketObject(s.buf, s.buf.Cursor(), '[', around, n)
+	}
+	return 0, 0, false
+}
+
+// WordObject finds the n words or runs of whitespace on the line from offset
+// i, the same runs w and b move over. With around it also takes the
+// whitespace after each word, or before the first one when there is none
+// after the last.
+func WordObject(buf TextBuffer, i int, around bool, n int) (int, int, bool) {
+	start, end := LineExtent(buf, i)
+	if start == end {
+		return 0, 0, false
+	}
+	i = min(i, end-1)
+	run := func(j int) (int, int) {
+		cls := charClass(buf.RuneAt(j))
+		from, to := j, j+1
+		for from > start && charClass(buf.RuneAt(from-1)) == cls {
+			from--
+		}
+		for to < end && charClass(buf.RuneAt(to)) == cls {
+			to++
+		}
+		return from, to
+	}
+	from, _ := run(i)
+	to := from
+	for k := 0; k < n && to < end; k++ {
+		onBlank := charClass(buf.RuneAt(to)) == blank
+		_, to = run(to)
+		if around && to < end && (onBlank || charClass(buf.RuneAt(to)) == blank) {
+			_, to = run(to)
+		}
+	}
+	if around && charClass(buf.RuneAt(to-1)) != blank && charClass(buf.RuneAt(i)) != blank {
+		for from > start && charClass(buf.RuneAt(from-1)) == blank {
+			from--
+		}
+	}
+	return from, to, true
+}
+
+// QuoteObject finds the string quoted with q on the line around offset i,
+// or the first one after it. Quotes pair up from the start of the line and
+// one escaped with a backslash does not count. With around it takes the
+// quotes and the whitespace after them, or before them when there is none.
+func QuoteObject(buf TextBuffer, i int, q rune, around bool) (int, int, bool) {
+	start, end := LineExtent(buf, i)
+	var quotes []int
+	for j := start; j < end; j++ {
+		if buf.RuneAt(j) == q && (j == start || buf.RuneAt(j-1) != '\\') {
+			quotes = append(quotes, j)
+		}
+	}
+	for k := 0; k+1 < len(quotes); k += 2 {
+		from, to := quotes[k], quotes[k+1]+1
+		if to <= i {
+			continue
+		}
+		if !around {
+			return from + 1, to - 1, true
+		}
+		if to < end && charClass(buf.RuneAt(to)) == blank {
+			for to < end && charClass(buf.RuneAt(to)) == blank {
+				to++
+			}
+		} else {
+			for from > start && charClass(buf.RuneAt(from-1)) == blank {
+				from--
+			}
+		}
+		return from, to, true
+	}
+	return 0, 0, false
+}
+
+// BracketObject finds the text inside the nth pair of brackets opened with
+// open around offset i, matched across lines like %. With around it takes
+// the brackets too.
+func BracketObject(buf TextBuffer, i int, open rune, around bool, n int) (int, int, bool) {
+	close := brackets[open]
+	from, to := i, -1
+	for ; n > 0; n-- {
+		depth := 0
+		for ; from >= 0; from-- {
+			r := buf.RuneAt(from)
+			if r == close && (from != i || to != -1) {
+				depth++
+			} else if r == open {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+		}
+		if from < 0 {
+			return 0, 0, false
+		}
+		if to = matchAt(buf, from); to == -1 {
+			return 0, 0, false
+		}
+		if n > 1 {
+			from--
+		}
+	}
+	if around {
+		return from, to + 1, true
+	}
+	return from + 1, to, true
+}
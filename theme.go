@@ -0,0 +1,115 @@
+package main
+
+import "github.com/gbin/goncurses"
+
+// Style names a part of the screen that is drawn in its own colors. Each
+// is also the number of the curses color pair it uses.
+type Style int16
+
+const (
+	StatusStyle     Style = iota + 1 // status bar
+	ErrorStyle                       // error messages
+	NumberStyle                      // line numbers in the gutter
+	VisualStyle                      // the visual selection
+	SearchStyle                      // the match of an incremental search
+	MatchStyle                       // every match of the last search with hlsearch
+	CursorLineStyle                  // the cursor line with cursorline
+	ListStyle                        // whitespace glyphs of list mode
+	PagerStyle                       // prompt at the bottom of the pager
+	KeywordStyle                     // keywords of highlighted source
+	StringStyle                      // string and character literals
+	CommentStyle                     // comments
+	ConstantStyle                    // numbers and named constants
+)
+
+// Colors are the foreground and background of a style, -1 for the
+// terminal's own, and attributes added to them
+type Colors struct {
+	fg   int16
+	bg   int16
+	attr goncurses.Char
+}
+
+// themes are the palettes :set theme picks from
+var themes = map[string]map[Style]Colors{
+	"dark": {
+		StatusStyle:     {goncurses.C_BLACK, goncurses.C_CYAN, 0},
+		ErrorStyle:      {goncurses.C_RED, -1, goncurses.A_BOLD},
+		NumberStyle:     {goncurses.C_YELLOW, -1, 0},
+		VisualStyle:     {goncurses.C_BLACK, goncurses.C_WHITE, 0},
+		SearchStyle:     {goncurses.C_BLACK, goncurses.C_YELLOW, 0},
+		MatchStyle:      {goncurses.C_BLACK, goncurses.C_GREEN, 0},
+		CursorLineStyle: {-1, goncurses.C_BLUE, 0},
+		ListStyle:       {goncurses.C_BLUE, -1, goncurses.A_DIM},
+		PagerStyle:      {goncurses.C_GREEN, -1, goncurses.A_BOLD},
+		KeywordStyle:    {goncurses.C_YELLOW, -1, goncurses.A_BOLD},
+		StringStyle:     {goncurses.C_GREEN, -1, 0},
+		CommentStyle:    {goncurses.C_CYAN, -1, 0},
+		ConstantStyle:   {goncurses.C_MAGENTA, -1, 0},
+	},
+	"light": {
+		StatusStyle:     {goncurses.C_WHITE, goncurses.C_BLUE, 0},
+		ErrorStyle:      {goncurses.C_RED, -1, goncurses.A_BOLD},
+		NumberStyle:     {goncurses.C_MAGENTA, -1, 0},
+		VisualStyle:     {goncurses.C_WHITE, goncurses.C_BLACK, 0},
+		SearchStyle:     {goncurses.C_BLACK, goncurses.C_YELLOW, 0},
+		MatchStyle:      {goncurses.C_BLACK, goncurses.C_CYAN, 0},
+		CursorLineStyle: {-1, goncurses.C_WHITE, 0},
+		ListStyle:       {goncurses.C_CYAN, -1, 0},
+		PagerStyle:      {goncurses.C_BLUE, -1, goncurses.A_BOLD},
+		KeywordStyle:    {goncurses.C_BLUE, -1, goncurses.A_BOLD},
+		StringStyle:     {goncurses.C_RED, -1, 0},
+		CommentStyle:    {goncurses.C_GREEN, -1, 0},
+		ConstantStyle:   {goncurses.C_MAGENTA, -1, 0},
+	},
+}
+
+// the theme used unless set otherwise
+const defaultTheme = "dark"
+
+// monochrome is how each style is drawn on a terminal without colors
+var monochrome = map[Style]goncurses.Char{
+	StatusStyle:     goncurses.A_REVERSE,
+	ErrorStyle:      goncurses.A_BOLD,
+	VisualStyle:     goncurses.A_REVERSE,
+	SearchStyle:     goncurses.A_REVERSE | goncurses.A_UNDERLINE,
+	MatchStyle:      goncurses.A_BOLD | goncurses.A_UNDERLINE,
+	CursorLineStyle: goncurses.A_UNDERLINE,
+	ListStyle:       goncurses.A_DIM,
+	PagerStyle:      goncurses.A_REVERSE,
+	KeywordStyle:    goncurses.A_BOLD,
+	CommentStyle:    goncurses.A_DIM,
+}
+
+// themeNames are the values theme takes
+var themeNames = []string{"dark", "light"}
+
+// StartColors turns on colors if the terminal has them, keeping its own
+// default foreground and background, and loads the theme
+func StartColors(s *State) {
+	if !goncurses.HasColors() || goncurses.StartColor() != nil {
+		return
+	}
+	goncurses.UseDefaultColors()
+	s.colors = true
+	LoadTheme(s)
+}
+
+// LoadTheme sets up the color pairs for the styles of the current theme
+func LoadTheme(s *State) {
+	if !s.colors {
+		return
+	}
+	for style, c := range themes[s.theme] {
+		goncurses.InitPair(int16(style), c.fg, c.bg)
+	}
+}
+
+// Attr is the attribute to draw style in, its color pair or, without
+// colors, the monochrome fallback
+func Attr(s *State, style Style) goncurses.Char {
+	if !s.colors {
+		return monochrome[style]
+	}
+	return goncurses.ColorPair(int16(style)) | themes[s.theme][style].attr
+}
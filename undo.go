@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// edit is a single insertion or deletion of text at a rune offset. While
+// it is pending, runes deleted by backspacing over the start of it are
+// gathered in back, last first, so merging is never more than an append.
+type edit struct {
+	offset int
+	text   []rune
+	back   []rune
+	insert bool
+}
+
+func (e edit) length() int {
+	return len(e.back) + len(e.text)
+}
+
+// settle moves the runes gathered in back to the front of text
+func (e *edit) settle() {
+	if len(e.back) == 0 {
+		return
+	}
+	text := make([]rune, 0, e.length())
+	for i := len(e.back) - 1; i >= 0; i-- {
+		text = append(text, e.back[i])
+	}
+	e.text, e.back = append(text, e.text...), nil
+}
+
+// change is the list of edits undone and redone as one step. Changes form
+// a tree: each one applies on top of its parent, 0 being the loaded text.
+type change struct {
+	id     int
+	parent int
+	next   int // child redo goes to, the one made or visited last
+	edits  []edit
+	time   time.Time
+}
+
+// UndoState describes one change in the undo tree for :undolist
+type UndoState struct {
+	Seq     int
+	Parent  int
+	Time    time.Time
+	Current bool
+}
+
+type editLog struct {
+	pending []edit
+	changes []change // by id, changes[i] has id i+1
+	cur     int      // id of the newest applied change, 0 for the loaded text
+	next    int      // child of the loaded text redo goes to
+	savedID int      // id of the newest applied change when the file was saved
+	version int      // bumped by every recorded edit
+}
+
+// current is the id of the newest applied change, 0 for the loaded text
+func (l *editLog) current() int {
+	return l.cur
+}
+
+// child is where redo goes from change id
+func (l *editLog) child(id int) *int {
+	if id == 0 {
+		return &l.next
+	}
+	return &l.changes[id-1].next
+}
+
+// record appends e to the pending change, merging it with the previous edit
+// when typing or backspacing over consecutive runes
+func (l *editLog) record(e edit) {
+	l.version++
+	if n := len(l.pending); n > 0 {
+		last := &l.pending[n-1]
+		if e.insert && last.insert && last.offset+last.length() == e.offset {
+			last.text = append(last.text, e.text...)
+			return
+		}
+		if !e.insert && !last.insert && e.offset+e.length() == last.offset {
+			for i := len(e.text) - 1; i >= 0; i-- {
+				last.back = append(last.back, e.text[i])
+			}
+			last.offset = e.offset
+			return
+		}
+		if !e.insert && !last.insert && e.offset == last.offset {
+			last.text = append(last.text, e.text...)
+			return
+		}
+	}
+	l.pending = append(l.pending, e)
+}
+
+// Checkpoint closes the pending edits into a single undo step, a new branch
+// of the tree when some changes had been undone
+func (tgb *TextGapBuffer) Checkpoint() {
+	l := &tgb.history
+	if len(l.pending) > 0 {
+		for i := range l.pending {
+			l.pending[i].settle()
+		}
+		id := len(l.changes) + 1
+		l.changes = append(l.changes, change{id: id, parent: l.cur, edits: l.pending, time: time.Now()})
+		*l.child(l.cur) = id
+		l.cur = id
+		l.pending = nil
+	}
+}
+
+// Undo reverts the last change and reports whether there was one
+func (tgb *TextGapBuffer) Undo() bool {
+	tgb.Checkpoint()
+	l := &tgb.history
+	if l.cur == 0 {
+		return false
+	}
+	tgb.undoChange()
+	return true
+}
+
+// Redo reapplies the last undone change on the current branch and reports
+// whether there was one
+func (tgb *TextGapBuffer) Redo() bool {
+	l := &tgb.history
+	if len(l.pending) > 0 || *l.child(l.cur) == 0 {
+		return false
+	}
+	tgb.redoChange(*l.child(l.cur))
+	return true
+}
+
+// Travel moves n states forward or, when n is negative, back through the
+// changes in the order they were made, wherever they are in the tree, like
+// g+ and g-. It reports whether it could move at all.
+func (tgb *TextGapBuffer) Travel(n int) bool {
+	tgb.Checkpoint()
+	l := &tgb.history
+	target := max(min(l.cur+n, len(l.changes)), 0)
+	if target == l.cur {
+		return false
+	}
+	// undo up to the closest state target is built on, then redo down to it
+	path := []int{}
+	onPath := map[int]bool{0: true}
+	for id := target; id != 0; id = l.changes[id-1].parent {
+		path = append(path, id)
+		onPath[id] = true
+	}
+	for !onPath[l.cur] {
+		tgb.undoChange()
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		if id := path[i]; l.changes[id-1].parent == l.cur {
+			tgb.redoChange(id)
+		}
+	}
+	return true
+}
+
+// UndoTree lists every change in the order they were made, the loaded text
+// first as sequence 0
+func (tgb *TextGapBuffer) UndoTree() []UndoState {
+	l := &tgb.history
+	states := []UndoState{{Current: l.cur == 0}}
+	for _, c := range l.changes {
+		states = append(states, UndoState{Seq: c.id, Parent: c.parent, Time: c.time, Current: c.id == l.cur})
+	}
+	return states
+}
+
+// CurrentChange is the sequence number of the newest applied change
+func CurrentChange(buf TextBuffer) int {
+	for _, u := range buf.UndoTree() {
+		if u.Current {
+			return u.Seq
+		}
+	}
+	return 0
+}
+
+// ShowUndoTree lists the undo tree in the pager with the time of each
+// change. A branch is indented under the change it forks from and > marks
+// the current state.
+func ShowUndoTree(s *State) error {
+	states := s.buf.UndoTree()
+	children := make(map[int][]int)
+	for _, u := range states[1:] {
+		children[u.Parent] = append(children[u.Parent], u.Seq)
+	}
+	s.pager = nil
+	var walk func(seq int, depth int)
+	walk = func(seq int, depth int) {
+		u := states[seq]
+		mark := ' '
+		if u.Current {
+			mark = '>'
+		}
+		line := fmt.Sprintf("%*s%c%4d  ", 2*depth, "", mark, seq)
+		if seq == 0 {
+			line += "original"
+		} else {
+			line += u.Time.Format("15:04:05")
+		}
+		if kids := children[u.Parent]; seq != 0 && kids[0] != seq {
+			line += fmt.Sprintf("  from %d", u.Parent)
+		}
+		s.pager = append(s.pager, Message{text: line})
+		kids := children[seq]
+		for i := len(kids) - 1; i > 0; i-- {
+			walk(kids[i], depth+1)
+		}
+		if len(kids) > 0 {
+			walk(kids[0], depth)
+		}
+	}
+	walk(0, 0)
+	s.pagerTop = 0
+	s.status = PAGER
+	return nil
+}
+
+// undoChange reverts the current change and moves to its parent
+func (tgb *TextGapBuffer) undoChange() {
+	l := &tgb.history
+	c := l.changes[l.cur-1]
+	for i := len(c.edits) - 1; i >= 0; i-- {
+		tgb.revert(c.edits[i])
+	}
+	tgb.MoveGapTo(c.edits[0].offset)
+	*l.child(c.parent) = c.id
+	l.cur = c.parent
+}
+
+// redoChange reapplies change id, a child of the current one
+func (tgb *TextGapBuffer) redoChange(id int) {
+	l := &tgb.history
+	c := l.changes[id-1]
+	for _, e := range c.edits {
+		tgb.apply(e)
+	}
+	tgb.MoveGapTo(c.edits[0].offset)
+	*l.child(c.parent) = id
+	l.cur = id
+}
+
+func (tgb *TextGapBuffer) apply(e edit) {
+	tgb.MoveGapTo(e.offset)
+	if e.insert {
+		tgb.insert(e.text)
+	} else {
+		tgb.remove(e.length())
+	}
+}
+
+func (tgb *TextGapBuffer) revert(e edit) {
+	tgb.MoveGapTo(e.offset)
+	if e.insert {
+		tgb.remove(e.length())
+	} else {
+		tgb.insert(e.text)
+	}
+}
+
+// Modified reports whether the buffer differs from the last save, which
+// stops being true again when undoing back to the saved text
+func (tgb *TextGapBuffer) Modified() bool {
+	l := &tgb.history
+	return len(l.pending) > 0 || l.current() != l.savedID
+}
+
+// Version changes whenever an edit is made, but not on undo or redo
+func (tgb *TextGapBuffer) Version() int {
+	return tgb.history.version
+}
+
+func (tgb *TextGapBuffer) MarkSaved() {
+	tgb.Checkpoint()
+	tgb.history.savedID = tgb.history.current()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// version of the undo file layout, bumped whenever undoFile changes
+const undoFormat = 1
+
+// undoFile is the undo tree as written to disk by :set undofile, along
+// with a checksum of the text it leads up to
+type undoFile struct {
+	Format  int
+	Sum     [sha256.Size]byte
+	Changes []undoChange
+	Cur     int
+	Next    int
+}
+
+type undoChange struct {
+	Parent int
+	Next   int
+	Edits  []undoEdit
+	Time   time.Time
+}
+
+type undoEdit struct {
+	Offset int
+	Text   string
+	Insert bool
+}
+
+// WriteHistory saves the undo tree to w along with the checksum of text,
+// the buffer as it went into the file, which a missing final newline may
+// have been added to
+func (tgb *TextGapBuffer) WriteHistory(w io.Writer, text string) error {
+	tgb.Checkpoint()
+	l := &tgb.history
+	f := undoFile{Format: undoFormat, Sum: sha256.Sum256([]byte(text)), Cur: l.cur, Next: l.next}
+	for _, c := range l.changes {
+		uc := undoChange{Parent: c.parent, Next: c.next, Time: c.time}
+		for _, e := range c.edits {
+			uc.Edits = append(uc.Edits, undoEdit{Offset: e.offset, Text: string(e.text), Insert: e.insert})
+		}
+		f.Changes = append(f.Changes, uc)
+	}
+	return gob.NewEncoder(w).Encode(f)
+}
+
+// ReadHistory replaces the undo tree with one saved by WriteHistory, as long
+// as it was saved for the text now in the buffer
+func (tgb *TextGapBuffer) ReadHistory(r io.Reader) error {
+	var f undoFile
+	if err := gob.NewDecoder(r).Decode(&f); err != nil || f.Format != undoFormat {
+		return errors.New("Incompatible undo file")
+	}
+	if f.Sum != sha256.Sum256([]byte(tgb.ReadAll())) {
+		return errors.New("File contents changed, cannot use undo info")
+	}
+	if f.Cur < 0 || f.Cur > len(f.Changes) || f.Next < 0 || f.Next > len(f.Changes) {
+		return errors.New("Corrupted undo file")
+	}
+	var changes []change
+	for i, uc := range f.Changes {
+		id := i + 1
+		if uc.Parent < 0 || uc.Parent >= id || uc.Next < 0 || uc.Next > len(f.Changes) || len(uc.Edits) == 0 {
+			return errors.New("Corrupted undo file")
+		}
+		c := change{id: id, parent: uc.Parent, next: uc.Next, time: uc.Time}
+		for _, e := range uc.Edits {
+			c.edits = append(c.edits, edit{offset: e.Offset, text: []rune(e.Text), insert: e.Insert})
+		}
+		changes = append(changes, c)
+	}
+	l := &tgb.history
+	l.changes, l.cur, l.next, l.pending = changes, f.Cur, f.Next, nil
+	l.savedID = f.Cur
+	return nil
+}
+
+// UndoPath is where the undo file for the file at path is kept, named by a
+// hash of its absolute path under the user's cache directory
+func UndoPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(cache, "gotext", "undo", hex.EncodeToString(sum[:])), nil
+}
+
+// SaveUndo writes the undo file for the file being edited when undofile is
+// set, text being what was written to it
+func SaveUndo(s *State, text string) error {
+	if !s.undofile || s.filename == "" {
+		return nil
+	}
+	path, err := UndoPath(s.filename)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	if err := s.buf.WriteHistory(&data, text); err != nil {
+		return err
+	}
+	_, err = WriteFile(path, data.String())
+	return err
+}
+
+// LoadUndo reads back the undo file for the file being edited when
+// undofile is set, a file without one keeps an empty history
+func LoadUndo(s *State) error {
+	if !s.undofile || s.filename == "" {
+		return nil
+	}
+	path, err := UndoPath(s.filename)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.buf.ReadHistory(f)
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gbin/goncurses"
+)
+
+// default columns between tab stops
+const defaultTabstop = 8
+
+// frame is what drawing a line needs to know about the window, worked out
+// once by PrintBuffer for all the lines it draws
+type frame struct {
+	rows   int // rows of text in the window
+	width  int // columns right of the gutter
+	gutter int // width of the line number gutter
+}
+
+// PrintBuffer draws the lines from topLine down after the gutter, rows past
+// the end of the buffer show ~
+func PrintBuffer(w *goncurses.Window, s *State) {
+	f := frame{rows: TextHeight(w), width: TextWidth(w, s), gutter: GutterWidth(s)}
+	lines := s.buf.LineCount()
+	offset := s.buf.LineStart(s.topLine)
+	row := 0
+	for y := s.topLine; row < f.rows && y < lines; y++ {
+		w.Move(row, 0)
+		if a := LineAttr(s, y); a != goncurses.A_NORMAL {
+			w.AttrSet(a)
+		} else {
+			w.AttrSet(Attr(s, NumberStyle))
+		}
+		PrintGutter(w, s, f, y)
+		w.AttrSet(goncurses.A_NORMAL)
+		start, end := LineExtent(s.buf, offset)
+		if s.wrap {
+			row += PrintWrapped(w, s, f, row, y, start, end)
+		} else {
+			PrintLine(w, s, f, row, y, start, end)
+			row++
+		}
+		_, offset = s.buf.LineBounds(offset)
+	}
+	for ; row < f.rows; row++ {
+		w.MovePrint(row, 0, "~")
+	}
+}
+
+// PrintWrapped draws line y, the text in [start, end), across as many rows as it
+// needs, stopping at the bottom of the frame, and returns the number of rows
+// used. Continuation rows leave the gutter blank.
+func PrintWrapped(w *goncurses.Window, s *State, f frame, row int, y int, start int, end int) int {
+	width := f.width
+	text := []rune(s.buf.Slice(start, end))
+	attr := TextAttr(s, start, text)
+	glyph := ListGlyphs(s, text)
+	syntax := SyntaxAttr(s, y, string(text))
+	line := LineAttr(s, y)
+	used, col := 1, 0
+	for i, r := range text {
+		rw := WrapWidth(r, col, width, s.tabstop)
+		if col+rw > width {
+			if row+used >= f.rows {
+				break
+			}
+			FillRow(w, line)
+			w.HLine(row+used, 0, ' '|line, f.gutter)
+			w.Move(row+used, f.gutter)
+			used++
+			col = 0
+		}
+		if a := attr(start + i); a != goncurses.A_NORMAL {
+			w.AttrSet(a)
+		} else if a := syntax(i); a != goncurses.A_NORMAL {
+			w.AttrSet(a)
+		} else {
+			w.AttrSet(line)
+		}
+		if g, ok := glyph(i, rw); ok {
+			if attr(start+i) == goncurses.A_NORMAL {
+				w.AttrSet(Attr(s, ListStyle))
+			}
+			w.Print(g)
+		} else if r == '\t' {
+			w.Print(strings.Repeat(" ", rw))
+		} else if IsControl(r) {
+			w.Print(ControlText(r))
+		} else {
+			w.Print(string(r))
+		}
+		col += rw
+	}
+	FillRow(w, line)
+	w.AttrSet(goncurses.A_NORMAL)
+	return used
+}
+
+// PrintLine draws the part of line y, the text in [start, end), that falls
+// between leftCol and the right edge of the window, with a < or > in the
+// first or last column where some of it is cut off
+func PrintLine(w *goncurses.Window, s *State, f frame, row int, y int, start int, end int) {
+	_, maxX := w.MaxYX()
+	width := f.width
+	var run []rune
+	runAttr := goncurses.A_NORMAL
+	flush := func() {
+		w.AttrSet(runAttr)
+		w.Print(string(run))
+		run = run[:0]
+	}
+	col := 0
+	left, right := false, false
+	text := []rune(s.buf.Slice(start, end))
+	attr := TextAttr(s, start, text)
+	glyph := ListGlyphs(s, text)
+	syntax := SyntaxAttr(s, y, string(text))
+	line := LineAttr(s, y)
+	for i, r := range text {
+		rw := CellWidth(r, col, s.tabstop)
+		if col < s.leftCol && (r != '\t' || col+rw <= s.leftCol) {
+			col += rw
+			left = true
+			continue
+		}
+		if col+rw > s.leftCol+width {
+			right = true
+			break
+		}
+		g, listed := glyph(i, rw)
+		a := attr(start + i)
+		if a == goncurses.A_NORMAL && listed {
+			a = Attr(s, ListStyle)
+		} else if a == goncurses.A_NORMAL {
+			a = syntax(i)
+		}
+		if a == goncurses.A_NORMAL {
+			a = line
+		}
+		if a != runAttr {
+			flush()
+			runAttr = a
+		}
+		if listed {
+			// like a tab, its glyph may be cut off by leftCol
+			g := []rune(g)
+			run = append(run, g[len(g)-(col+rw-max(col, s.leftCol)):]...)
+		} else if r == '\t' {
+			// a tab cut off by leftCol shows only its visible part
+			run = append(run, []rune(strings.Repeat(" ", col+rw-max(col, s.leftCol)))...)
+		} else if IsControl(r) {
+			run = append(run, []rune(ControlText(r))...)
+		} else {
+			run = append(run, r)
+		}
+		col += rw
+	}
+	flush()
+	FillRow(w, line)
+	w.AttrSet(goncurses.A_NORMAL)
+	if left {
+		w.MovePrint(row, f.gutter, "<")
+	}
+	if right {
+		w.MovePrint(row, maxX-1, ">")
+	}
+}
+
+// LineAttr is the attribute line y is drawn in where nothing else is
+// highlighted, underlined for the cursor line with cursorline
+func LineAttr(s *State, y int) goncurses.Char {
+	if s.cursorline && y == s.y {
+		return Attr(s, CursorLineStyle)
+	}
+	return goncurses.A_NORMAL
+}
+
+// FillRow pads the rest of the row the window cursor is on with blanks in
+// attr, leaving the cursor where it is
+func FillRow(w *goncurses.Window, attr goncurses.Char) {
+	if attr == goncurses.A_NORMAL {
+		return
+	}
+	y, x := w.CursorYX()
+	_, maxX := w.MaxYX()
+	if x < maxX {
+		w.HLine(y, x, ' '|attr, maxX-x)
+	}
+}
+
+// the listchars shown unless set otherwise
+const defaultListChars = "tab:» ,trail:·,nbsp:+"
+
+// ListChars are the glyphs list mode draws in place of whitespace, 0 for
+// those left as they are. A tab is drawn as tab then tabFill up to its
+// width.
+type ListChars struct {
+	tab     rune
+	tabFill rune
+	trail   rune
+	nbsp    rune
+}
+
+// ParseListChars reads a listchars setting, a comma separated list of
+// tab:xy, trail:c and nbsp:c
+func ParseListChars(text string) (ListChars, error) {
+	var lc ListChars
+	for _, item := range strings.Split(text, ",") {
+		name, value, _ := strings.Cut(item, ":")
+		r := []rune(value)
+		switch {
+		case name == "tab" && len(r) == 2:
+			lc.tab, lc.tabFill = r[0], r[1]
+		case name == "trail" && len(r) == 1:
+			lc.trail = r[0]
+		case name == "nbsp" && len(r) == 1:
+			lc.nbsp = r[0]
+		case item != "":
+			return lc, errors.New("Invalid argument: listchars=" + text)
+		}
+	}
+	return lc, nil
+}
+
+// ListGlyphs gives, for the rune at index i of a line's text taking width
+// cells, what list mode draws instead and whether it draws anything
+// different at all. Trailing spaces are those after the last character
+// that is not a space.
+func ListGlyphs(s *State, text []rune) func(i int, width int) (string, bool) {
+	lc, err := ParseListChars(s.listchars)
+	if !s.list || err != nil {
+		return func(int, int) (string, bool) { return "", false }
+	}
+	trail := len(text)
+	for trail > 0 && text[trail-1] == ' ' {
+		trail--
+	}
+	return func(i int, width int) (string, bool) {
+		switch r := text[i]; {
+		case r == '\t' && lc.tab != 0:
+			return string(lc.tab) + strings.Repeat(string(lc.tabFill), width-1), true
+		case r == ' ' && i >= trail && lc.trail != 0:
+			return string(lc.trail), true
+		case r == '\u00a0' && lc.nbsp != 0:
+			return string(lc.nbsp), true
+		}
+		return "", false
+	}
+}
+
+// TextAttr returns the attribute for the rune at an offset of the line of
+// text starting at start: the selection, the incremental search match and
+// with hlsearch every match of the last search
+func TextAttr(s *State, start int, text []rune) func(int) goncurses.Char {
+	matched := SearchMatches(s, text)
+	from, to := s.buf.Selection()
+	if s.status != VISUAL && s.status != VISUAL_LINE {
+		from, to = 0, 0
+	}
+	return func(i int) goncurses.Char {
+		if i >= from && i < to {
+			return Attr(s, VisualStyle)
+		}
+		if (s.status == SEARCH || s.status == CONFIRM) && i >= s.incFrom && i < s.incTo {
+			return Attr(s, SearchStyle)
+		}
+		if i >= start && i < start+len(text) && matched(i-start) {
+			return Attr(s, MatchStyle)
+		}
+		return goncurses.A_NORMAL
+	}
+}
+
+// TextHeight is the number of rows of a view's window available for buffer
+// text, the bottom one holds its status bar
+func TextHeight(w *goncurses.Window) int {
+	maxY, _ := w.MaxYX()
+	return max(maxY-1, 0)
+}
+
+// TextWidth is the number of columns available for buffer text
+func TextWidth(w *goncurses.Window, s *State) int {
+	_, maxX := w.MaxYX()
+	return max(maxX-GutterWidth(s), 0)
+}
+
+// ScrollToCursor moves topLine and leftCol just far enough that the cursor
+// is inside the height rows and width columns shown, with scrolloff lines
+// of context above and below it where the buffer has them. Horizontally it
+// keeps a column of room at either edge for the < and > markers. A window
+// too small to show any text is treated as one row and column.
+func ScrollToCursor(s *State, height int, width int) {
+	height, width = max(height, 1), max(width, 2)
+	off := min(s.scrolloff, (height-1)/2)
+	above, below := max(s.y-off, 0), min(off, s.buf.LineCount()-1-s.y)
+	if s.wrap {
+		s.leftCol = 0
+		if above < s.topLine {
+			s.topLine = above
+		}
+		row, _ := CursorScreen(s, width)
+		for row >= height-below && s.topLine < s.y {
+			s.topLine++
+			row, _ = CursorScreen(s, width)
+		}
+		return
+	}
+	if above < s.topLine {
+		s.topLine = above
+	} else if s.y+below >= s.topLine+height {
+		s.topLine = s.y + below - height + 1
+	}
+	col := s.buf.CursorColumn(s.tabstop)
+	if col >= s.leftCol+width-1 {
+		s.leftCol = col - width + 2
+	} else if s.leftCol > 0 && col <= s.leftCol {
+		s.leftCol = max(col-1, 0)
+	}
+}
+
+// GutterWidth is the number of columns left of the text, wide enough for
+// the last line number and a space
+func GutterWidth(s *State) int {
+	if !s.number && !s.relative {
+		return 0
+	}
+	return len(strconv.Itoa(s.buf.LineCount())) + 1
+}
+
+// PrintGutter draws the number of buffer line y. With relativenumber it is
+// the distance from the cursor line, which shows 0 or, when number is also
+// set, its absolute number like vim's hybrid mode.
+func PrintGutter(w *goncurses.Window, s *State, f frame, y int) {
+	width := f.gutter
+	if width == 0 {
+		return
+	}
+	n := y + 1
+	if s.relative && (y != s.y || !s.number) {
+		n = max(y-s.y, s.y-y)
+	}
+	w.Printf("%*d ", width-1, n)
+}
+
+// CursorScreen maps the cursor's buffer line and column to the window row
+// and column it is drawn at, given the width of the text area
+func CursorScreen(s *State, width int) (int, int) {
+	if !s.wrap {
+		return s.y - s.topLine, GutterWidth(s) + s.buf.CursorColumn(s.tabstop) - s.leftCol
+	}
+	row := 0
+	for y := s.topLine; y < s.y; y++ {
+		row += WrappedRows(LineText(s.buf, y), width, s.tabstop)
+	}
+	r, col := WrapPosition(LineText(s.buf, s.y), Column(s), width, s.tabstop)
+	return row + r, GutterWidth(s) + col
+}
+
+// LineText is the text of line y without its newline
+func LineText(buf TextBuffer, y int) []rune {
+	start, end := LineExtent(buf, buf.LineStart(y))
+	return []rune(buf.Slice(start, end))
+}
+
+// WrapWidth is the number of cells r takes up at column col of a row
+// width cells wide, a tab is cut short at the end of the row rather than
+// pushed onto the next one
+func WrapWidth(r rune, col int, width int, tabstop int) int {
+	rw := CellWidth(r, col, tabstop)
+	if r == '\t' && col < width {
+		rw = min(rw, width-col)
+	}
+	return rw
+}
+
+// WrapPosition is the row and column rune x of a line is drawn at when the
+// line is wrapped at width columns
+func WrapPosition(text []rune, x int, width int, tabstop int) (int, int) {
+	row, col := 0, 0
+	for i := 0; i <= x && i < len(text); i++ {
+		rw := WrapWidth(text[i], col, width, tabstop)
+		if col+rw > width {
+			row++
+			col = 0
+		}
+		if i == x {
+			break
+		}
+		col += rw
+	}
+	if x >= len(text) && col >= width {
+		row++
+		col = 0
+	}
+	return row, col
+}
+
+// WrappedRows is the number of rows a line takes up when wrapped at width
+// columns
+func WrappedRows(text []rune, width int, tabstop int) int {
+	if len(text) == 0 {
+		return 1
+	}
+	row, _ := WrapPosition(text, len(text)-1, width, tabstop)
+	return row + 1
+}
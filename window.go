@@ -0,0 +1,262 @@
+package main
+
+import (
+	"github.com/gbin/goncurses"
+)
+
+// View is a window onto a document with a cursor and scroll position of
+// its own. The focused view keeps them in State while it has the focus.
+type View struct {
+	doc     *Document
+	y       int
+	x       int
+	topLine int
+	leftCol int
+	// screen region the view was last drawn in, its status bar included
+	row  int
+	col  int
+	rows int
+	cols int
+}
+
+// Layout is a tree of split windows: a leaf holds a view and other nodes
+// split their area between their children, side by side when vertical
+type Layout struct {
+	view     *View
+	vertical bool
+	children []*Layout
+	parent   *Layout
+}
+
+// StashView saves the cursor and scroll position of the focused view from
+// State
+func StashView(s *State) {
+	v := s.focus.view
+	v.doc, v.y, v.x, v.topLine, v.leftCol = s.Document, s.y, s.x, s.topLine, s.leftCol
+}
+
+// FocusView moves the focus to leaf l
+func FocusView(s *State, l *Layout) {
+	StashView(s)
+	showView(s, l)
+}
+
+// showView focuses leaf l, putting its document, cursor and scroll
+// position in State
+func showView(s *State, l *Layout) {
+	s.focus = l
+	v := l.view
+	if v.doc != s.Document {
+		SwitchTo(s, v.doc)
+	}
+	s.topLine, s.leftCol = v.topLine, v.leftCol
+	s.buf.ChangeCursorPosition(max(min(v.y, s.buf.LineCount()-1), 0), 0)
+	SyncCursor(s)
+	MoveToColumn(s, v.x)
+	s.x = v.x // keep a column past the end, as moving there last did
+}
+
+// SplitView opens a second view of the focused one's document above it, or
+// to its left when vertical, and focuses it
+func SplitView(s *State, vertical bool) {
+	StashView(s)
+	old := s.focus
+	view := *old.view
+	leaf := &Layout{view: &view}
+	parent := old.parent
+	if parent == nil || parent.vertical != vertical {
+		// the old leaf becomes a split holding both views
+		moved := &Layout{view: old.view, parent: old}
+		leaf.parent = old
+		old.view, old.vertical, old.children = nil, vertical, []*Layout{leaf, moved}
+		s.focus = leaf
+		return
+	}
+	leaf.parent = parent
+	for i, c := range parent.children {
+		if c == old {
+			parent.children = append(parent.children[:i], append([]*Layout{leaf}, parent.children[i:]...)...)
+			break
+		}
+	}
+	s.focus = leaf
+}
+
+// CloseView closes the focused view and focuses the one that takes its
+// place, reporting false when it is the last one
+func CloseView(s *State) bool {
+	l := s.focus
+	parent := l.parent
+	if parent == nil {
+		return false
+	}
+	i := 0
+	for i = range parent.children {
+		if parent.children[i] == l {
+			break
+		}
+	}
+	parent.children = append(parent.children[:i], parent.children[i+1:]...)
+	next := parent.children[min(i, len(parent.children)-1)]
+	if len(parent.children) == 1 {
+		// a split with one view left is just that view
+		only := parent.children[0]
+		parent.view, parent.vertical, parent.children = only.view, only.vertical, only.children
+		for _, c := range parent.children {
+			c.parent = parent
+		}
+		next = parent
+	}
+	for next.view == nil {
+		next = next.children[0]
+	}
+	showView(s, next) // the closed view is not stashed
+	return true
+}
+
+// Leaves lists the views in the layout from top left to bottom right
+func Leaves(l *Layout) []*Layout {
+	if l.view != nil {
+		return []*Layout{l}
+	}
+	var leaves []*Layout
+	for _, c := range l.children {
+		leaves = append(leaves, Leaves(c)...)
+	}
+	return leaves
+}
+
+// PlaceViews shares out the region of rows and cols at row, col between
+// the views of the layout. Views side by side are kept apart by a column
+// for the separator.
+func PlaceViews(l *Layout, row int, col int, rows int, cols int) {
+	if l.view != nil {
+		l.view.row, l.view.col, l.view.rows, l.view.cols = row, col, rows, cols
+		return
+	}
+	n := len(l.children)
+	for i, c := range l.children {
+		if l.vertical {
+			width := (cols - (n - 1)) / n
+			if i == n-1 {
+				width = cols - (width+1)*(n-1)
+			}
+			PlaceViews(c, row, col, rows, width)
+			col += width + 1
+		} else {
+			height := rows / n
+			if i == n-1 {
+				height = rows - height*(n-1)
+			}
+			PlaceViews(c, row, col, height, cols)
+			row += height
+		}
+	}
+}
+
+// MoveFocus focuses the view next to the focused one in the direction of
+// vim key dir, h, j, k or l, the one beside the cursor when there are
+// several
+func MoveFocus(s *State, dir byte) {
+	v := s.focus.view
+	row, col := CursorScreen(s, v.cols)
+	row, col = v.row+min(row, v.rows-1), v.col+min(col, v.cols-1)
+	var best *Layout
+	for _, l := range Leaves(s.layout) {
+		o := l.view
+		var beside bool
+		switch dir {
+		case 'h':
+			beside = o.col+o.cols+1 == v.col && row >= o.row && row < o.row+o.rows
+		case 'l':
+			beside = v.col+v.cols+1 == o.col && row >= o.row && row < o.row+o.rows
+		case 'k':
+			beside = o.row+o.rows == v.row && col >= o.col && col <= o.col+o.cols
+		case 'j':
+			beside = v.row+v.rows == o.row && col >= o.col && col <= o.col+o.cols
+		}
+		if beside && l != s.focus {
+			best = l
+			break
+		}
+	}
+	if best != nil {
+		FocusView(s, best)
+	}
+}
+
+// NextView focuses the view after the focused one, wrapping around, like
+// ctrl-w w
+func NextView(s *State) {
+	leaves := Leaves(s.layout)
+	for i, l := range leaves {
+		if l == s.focus {
+			FocusView(s, leaves[(i+1)%len(leaves)])
+			return
+		}
+	}
+}
+
+// DrawViews draws every view with its status bar, separators between the
+// ones side by side, and returns where the cursor of the focused view is on
+// the screen. The bottom row is left for the message line.
+func DrawViews(src *goncurses.Window, s *State, note string) (int, int) {
+	maxY, maxX := src.MaxYX()
+	PlaceViews(s.layout, 0, 0, max(maxY-1, 1), maxX)
+	var cursorRow, cursorCol int
+	for _, l := range Leaves(s.layout) {
+		v := l.view
+		if v.rows < 1 || v.cols < 1 {
+			continue
+		}
+		w := src.Derived(v.rows, v.cols, v.row, v.col)
+		if l == s.focus {
+			s.height = TextHeight(w)
+			if s.status == SEARCH && s.incTo > s.incFrom {
+				ScrollToMatch(s, s.height, TextWidth(w, s))
+			} else {
+				ScrollToCursor(s, s.height, TextWidth(w, s))
+			}
+			PrintBuffer(w, s)
+			PrintStatus(w, s, note)
+			row, col := CursorScreen(s, TextWidth(w, s))
+			cursorRow, cursorCol = v.row+row, v.col+col
+		} else {
+			// draw from a copy of the state with this view's document and
+			// position, which is never focused so it shows no selection
+			other := *s
+			other.Document, other.status = v.doc, NORMAL
+			other.y = min(v.y, v.doc.buf.LineCount()-1)
+			other.x, other.leftCol = v.x, v.leftCol
+			other.topLine = min(v.topLine, other.y)
+			PrintBuffer(w, &other)
+			PrintStatus(w, &other, "")
+		}
+		w.Delete()
+		if v.col+v.cols < maxX {
+			for row := v.row; row < v.row+v.rows; row++ {
+				src.MovePrint(row, v.col+v.cols, "|")
+			}
+		}
+	}
+	StashView(s)
+	src.Touch() // the views were drawn through windows of their own
+	return cursorRow, cursorCol
+}
+
+// HandleWindow carries out the ctrl-w command for key
+func HandleWindow(s *State, key byte) error {
+	switch key {
+	case 'h', 'j', 'k', 'l':
+		MoveFocus(s, key)
+	case 'w', 0x17: // w, ctrl-w
+		NextView(s)
+	case 's', 'S':
+		SplitView(s, false)
+	case 'v':
+		SplitView(s, true)
+	case 'q', 'c':
+		return Quit(s, false)
+	}
+	return nil
+}